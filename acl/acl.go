@@ -0,0 +1,238 @@
+package acl
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	TagUserObj  = 0x01
+	TagUser     = 0x02
+	TagGroupObj = 0x04
+	TagGroup    = 0x08
+	TagMask     = 0x10
+	TagOther    = 0x20
+
+	PermRead    = 0x4
+	PermWrite   = 0x2
+	PermExecute = 0x1
+
+	// Version is the version of the compact on-disk format, while
+	// xattrVersion is the one of the format used by the xattr system
+	// calls.
+	Version      = 1
+	xattrVersion = 2
+
+	undefinedId = 0xFFFFFFFF
+)
+
+// Entry grants Perm to the owner (TagUserObj), to a user or group by Id
+// (TagUser and TagGroup), to the owning group (TagGroupObj), to everyone
+// else (TagOther), or limits every entry but the owner and others
+// (TagMask).
+type Entry struct {
+	Tag  int
+	Perm int
+	Id   int
+}
+
+func hasId(tag int) bool {
+	return tag == TagUser || tag == TagGroup
+}
+
+// Sort puts entries in the order the kernel expects them in: by tag, then
+// by id.
+func Sort(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Tag != entries[j].Tag {
+			return entries[i].Tag < entries[j].Tag
+		}
+		return entries[i].Id < entries[j].Id
+	})
+}
+
+// Validate checks that a sorted ACL has exactly one owner, owning group
+// and others entry, no duplicate users or groups, and a mask if it has
+// any user or group entries.
+func Validate(entries []Entry) error {
+	counts := map[int]int{}
+	for i, entry := range entries {
+		if entry.Perm&^(PermRead|PermWrite|PermExecute) != 0 {
+			return errors.New("invalid ACL permissions")
+		}
+		switch entry.Tag {
+		case TagUserObj, TagGroupObj, TagMask, TagOther:
+		case TagUser, TagGroup:
+			if entry.Id < 0 || int64(entry.Id) >= undefinedId {
+				return errors.New("invalid ACL id")
+			}
+			if i > 0 && entries[i-1].Tag == entry.Tag && entries[i-1].Id == entry.Id {
+				return errors.New("duplicate ACL entry")
+			}
+		default:
+			return errors.New("invalid ACL tag")
+		}
+		if i > 0 && entries[i-1].Tag > entry.Tag {
+			return errors.New("unsorted ACL entries")
+		}
+		counts[entry.Tag] += 1
+	}
+	if counts[TagUserObj] != 1 || counts[TagGroupObj] != 1 || counts[TagOther] != 1 || counts[TagMask] > 1 {
+		return errors.New("invalid ACL")
+	}
+	if counts[TagMask] == 0 && counts[TagUser]+counts[TagGroup] > 0 {
+		return errors.New("ACL needs a mask entry")
+	}
+	return nil
+}
+
+// IsMinimal reports whether an ACL says nothing beyond the permission
+// bits of the file mode.
+func IsMinimal(entries []Entry) bool {
+	for _, entry := range entries {
+		if entry.Tag != TagUserObj && entry.Tag != TagGroupObj && entry.Tag != TagOther {
+			return false
+		}
+	}
+	return true
+}
+
+// Mode returns the permission bits of the file mode that match an ACL.
+// The group bits come from the mask if there is one.
+func Mode(entries []Entry) int {
+	owner, group, mask, other := 0, 0, -1, 0
+	for _, entry := range entries {
+		switch entry.Tag {
+		case TagUserObj:
+			owner = entry.Perm
+		case TagGroupObj:
+			group = entry.Perm
+		case TagMask:
+			mask = entry.Perm
+		case TagOther:
+			other = entry.Perm
+		}
+	}
+	if mask != -1 {
+		group = mask
+	}
+	return owner<<6 | group<<3 | other
+}
+
+// Chmod updates the owner, others and group (or mask) entries of an ACL
+// to the permission bits of mode.
+func Chmod(entries []Entry, mode int) {
+	hasMask := false
+	for _, entry := range entries {
+		if entry.Tag == TagMask {
+			hasMask = true
+		}
+	}
+	for i := range entries {
+		switch entries[i].Tag {
+		case TagUserObj:
+			entries[i].Perm = mode >> 6 & 7
+		case TagGroupObj:
+			if !hasMask {
+				entries[i].Perm = mode >> 3 & 7
+			}
+		case TagMask:
+			entries[i].Perm = mode >> 3 & 7
+		case TagOther:
+			entries[i].Perm = mode & 7
+		}
+	}
+}
+
+// Encode returns an ACL in the compact on-disk format, where only user and
+// group entries store an id.
+func Encode(entries []Entry) []byte {
+	le := binary.LittleEndian
+	size := 4
+	for _, entry := range entries {
+		size += 4
+		if hasId(entry.Tag) {
+			size += 4
+		}
+	}
+	data := make([]byte, size)
+	le.PutUint32(data, Version)
+	offset := 4
+	for _, entry := range entries {
+		le.PutUint16(data[offset:], uint16(entry.Tag))
+		le.PutUint16(data[offset+2:], uint16(entry.Perm))
+		offset += 4
+		if hasId(entry.Tag) {
+			le.PutUint32(data[offset:], uint32(entry.Id))
+			offset += 4
+		}
+	}
+	return data
+}
+
+func Decode(data []byte) ([]Entry, error) {
+	le := binary.LittleEndian
+	if len(data) < 4 || le.Uint32(data) != Version {
+		return nil, errors.New("invalid ACL")
+	}
+	entries := []Entry{}
+	for offset := 4; offset < len(data); {
+		if offset+4 > len(data) {
+			return nil, errors.New("invalid ACL")
+		}
+		entry := Entry{
+			Tag:  int(le.Uint16(data[offset:])),
+			Perm: int(le.Uint16(data[offset+2:])),
+		}
+		offset += 4
+		if hasId(entry.Tag) {
+			if offset+4 > len(data) {
+				return nil, errors.New("invalid ACL")
+			}
+			entry.Id = int(le.Uint32(data[offset:]))
+			offset += 4
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// EncodeXattr returns an ACL in the format of the system.posix_acl_access
+// and system.posix_acl_default attributes as seen through the xattr system
+// calls.
+func EncodeXattr(entries []Entry) []byte {
+	le := binary.LittleEndian
+	data := make([]byte, 4+len(entries)*8)
+	le.PutUint32(data, xattrVersion)
+	for i, entry := range entries {
+		id := uint32(undefinedId)
+		if hasId(entry.Tag) {
+			id = uint32(entry.Id)
+		}
+		offset := 4 + i*8
+		le.PutUint16(data[offset:], uint16(entry.Tag))
+		le.PutUint16(data[offset+2:], uint16(entry.Perm))
+		le.PutUint32(data[offset+4:], id)
+	}
+	return data
+}
+
+func DecodeXattr(data []byte) ([]Entry, error) {
+	le := binary.LittleEndian
+	if len(data) < 4 || (len(data)-4)%8 != 0 || le.Uint32(data) != xattrVersion {
+		return nil, errors.New("invalid ACL")
+	}
+	entries := []Entry{}
+	for offset := 4; offset < len(data); offset += 8 {
+		entry := Entry{
+			Tag:  int(le.Uint16(data[offset:])),
+			Perm: int(le.Uint16(data[offset+2:])),
+		}
+		if hasId(entry.Tag) {
+			entry.Id = int(le.Uint32(data[offset+4:]))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
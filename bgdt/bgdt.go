@@ -1,11 +1,11 @@
 package bgdt
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"math"
-	"time"
+	"strconv"
 
 	"github.com/ErrorNoInternet/mkfs.ext2/device"
 	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
@@ -37,6 +37,9 @@ type BgdtEntry struct {
 	NumFreeBlocks       int
 	NumFreeInodes       int
 	NumInodesAsDirs     int
+	Flags               int
+	ItableUnused        int
+	Checksum            int
 	Device              *device.Device
 	Superblock          *superblock.Superblock
 }
@@ -49,6 +52,7 @@ func (bgdtEntry *BgdtEntry) SetNumFreeBlocks(numFreeBlocks int) error {
 		return err
 	}
 	bgdtEntry.WriteData(12, bytes)
+	bgdtEntry.UpdateChecksum()
 	return nil
 }
 
@@ -60,6 +64,7 @@ func (bgdtEntry *BgdtEntry) SetNumFreeInodes(numFreeInodes int) error {
 		return err
 	}
 	bgdtEntry.WriteData(14, bytes)
+	bgdtEntry.UpdateChecksum()
 	return nil
 }
 
@@ -71,6 +76,34 @@ func (bgdtEntry *BgdtEntry) SetNumInodesAsDirs(numInodesAsDirs int) error {
 		return err
 	}
 	bgdtEntry.WriteData(16, bytes)
+	bgdtEntry.UpdateChecksum()
+	return nil
+}
+
+// SetFlags sets the Flag* values of the block group.
+func (bgdtEntry *BgdtEntry) SetFlags(flags int) error {
+	bgdtEntry.Flags = flags
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"H"}, []interface{}{flags})
+	if err != nil {
+		return err
+	}
+	bgdtEntry.WriteData(18, bytes)
+	bgdtEntry.UpdateChecksum()
+	return nil
+}
+
+// SetItableUnused sets the number of inodes at the end of the inode table
+// that have never been used.
+func (bgdtEntry *BgdtEntry) SetItableUnused(itableUnused int) error {
+	bgdtEntry.ItableUnused = itableUnused
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"H"}, []interface{}{itableUnused})
+	if err != nil {
+		return err
+	}
+	bgdtEntry.WriteData(28, bytes)
+	bgdtEntry.UpdateChecksum()
 	return nil
 }
 
@@ -83,13 +116,25 @@ func (bgdtEntry *BgdtEntry) WriteData(offset int64, data []byte) {
 			break
 		}
 	}
-	bgdtEntry.Superblock.TimeLastWrite = time.Now().Unix()
 }
 
+// New lays out the block groups of sb and writes the block group
+// descriptor table of group bgNumCopy. For the primary table (group 0) it
+// also writes the bitmaps and zeroes the inode table of every group,
+// calling progress, if it isn't nil, after each one. It stops between
+// groups once ctx is cancelled.
+//
+// If sb has uninit_bg, the descriptors get checksums and flags that let
+// the kernel skip groups that are still empty. With lazyItableInit, only
+// the part of each inode table that's in use is zeroed, and the kernel
+// zeroes the rest once the filesystem is mounted.
 func New(
+	ctx context.Context,
 	bgNumCopy int,
 	sb *superblock.Superblock,
 	dev *device.Device,
+	lazyItableInit bool,
+	progress func(phase string, done, total int),
 ) (*Bgdt, error) {
 	bgdt := &Bgdt{}
 	bgdt.Entries = []*BgdtEntry{}
@@ -97,8 +142,15 @@ func New(
 	bgdt.NumBgdtBlocks = int(math.Ceil(float64(sb.NumBlockGroups*32) / float64(sb.BlockSize)))
 	bgdt.InodeTableBlocks = int(math.Ceil(float64(sb.NumInodesPerGroup*sb.InodeSize) / float64(sb.BlockSize)))
 
+	checksums := sb.FeaturesReadOnlyCompatible&superblock.FeatureReadOnlyCompatGdtCsum != 0
+	if lazyItableInit && !checksums {
+		return bgdt, errors.New("lazy inode table initialization requires uninit_bg")
+	}
 	bgdtBytes := []byte("")
 	for bgroupNum := 0; bgroupNum < sb.NumBlockGroups; bgroupNum++ {
+		if err := ctx.Err(); err != nil {
+			return bgdt, err
+		}
 		bgroupStartBid := bgroupNum*sb.NumBlocksPerGroup + sb.FirstBlockId
 		bgdt.BlockBitmapLocation = bgroupStartBid
 		bgdt.InodeBitmapLocation = bgroupStartBid + 1
@@ -136,6 +188,17 @@ func New(
 			return bgdt, errors.New("not enough blocks specified")
 		}
 
+		flags := 0
+		if bgdt.NumUsedInodes == 0 {
+			flags |= FlagInodeUninit
+		}
+		if bgroupNum != sb.NumBlockGroups-1 {
+			flags |= FlagBlockUninit
+		}
+		if !lazyItableInit {
+			flags |= FlagInodeZeroed
+		}
+
 		if bgNumCopy == 0 {
 			blockBitmap := []uint8{}
 			for i := 0; i < sb.BlockSize; i++ {
@@ -150,8 +213,8 @@ func New(
 				}
 			}
 			padBitIndex := bgdt.NumTotalBlocksInGroup
-			for padBitIndex < sb.BlockSize {
-				blockBitmap[padBitIndex>>8] |= (1 << (padBitIndex & 0x07))
+			for padBitIndex < sb.BlockSize*8 {
+				blockBitmap[padBitIndex>>3] |= (1 << (padBitIndex & 0x07))
 				padBitIndex += 1
 			}
 			dev.Write(
@@ -171,47 +234,91 @@ func New(
 					bitmapIndex += 1
 				}
 			}
+			padBitIndex = sb.NumInodesPerGroup
+			for padBitIndex < sb.BlockSize*8 {
+				inodeBitmap[padBitIndex>>3] |= (1 << (padBitIndex & 0x07))
+				padBitIndex += 1
+			}
 			dev.Write(
 				int64(bgdt.InodeBitmapLocation*sb.BlockSize),
 				[]byte(inodeBitmap),
 			)
+
+			zeroBlocks := bgdt.InodeTableBlocks
+			if lazyItableInit {
+				zeroBlocks = (bgdt.NumUsedInodes*sb.InodeSize + sb.BlockSize - 1) / sb.BlockSize
+			}
+			err := dev.Zero(
+				int64(bgdt.InodeTableLocation)*int64(sb.BlockSize),
+				int64(zeroBlocks)*int64(sb.BlockSize),
+			)
+			if err != nil {
+				return bgdt, errors.New("unable to zero inode table: " + err.Error())
+			}
+			if progress != nil {
+				progress("writing inode tables", bgroupNum+1, sb.NumBlockGroups)
+			}
 		}
-		bp := new(binary_pack.BinaryPack)
-		entryBytes, err := bp.Pack(
-			[]string{"I", "I", "I", "H", "H", "H"},
-			[]interface{}{
-				bgdt.BlockBitmapLocation,
-				bgdt.InodeBitmapLocation,
-				bgdt.InodeTableLocation,
-				bgdt.NumFreeBlocks,
-				bgdt.NumFreeInodes,
-				bgdt.NumInodesAsDirs,
-			},
-		)
-		if err != nil {
-			return bgdt, errors.New("unable to pack bytes: " + err.Error())
-		}
-		emptyBytes := []byte("")
-		for i := 0; i < 14; i++ {
-			emptyBytes = binary.AppendVarint(emptyBytes, 0)
-		}
-		bgdtBytes = bytes.Join([][]byte{bgdtBytes, entryBytes, emptyBytes}, []byte(""))
-
-		startPos := bgroupNum * 32
 		entry := &BgdtEntry{
-			StartPos:            startPos,
+			StartPos:            bgroupNum * 32,
 			Device:              dev,
 			Superblock:          sb,
 			BlockBitmapLocation: bgdt.BlockBitmapLocation,
 			InodeBitmapLocation: bgdt.InodeBitmapLocation,
 			InodeTableLocation:  bgdt.InodeTableLocation,
+			InodeTableBlocks:    bgdt.InodeTableBlocks,
+			NumFreeBlocks:       bgdt.NumFreeBlocks,
+			NumFreeInodes:       bgdt.NumFreeInodes,
+			NumInodesAsDirs:     bgdt.NumInodesAsDirs,
+		}
+		if checksums {
+			entry.Flags = flags
+			entry.ItableUnused = sb.NumInodesPerGroup - bgdt.NumUsedInodes
+			entry.Checksum = entry.checksum()
 		}
-		entry.SetNumFreeBlocks(bgdt.NumFreeBlocks)
-		entry.SetNumFreeInodes(bgdt.NumFreeInodes)
-		entry.SetNumInodesAsDirs(bgdt.NumInodesAsDirs)
+		bgdtBytes = append(bgdtBytes, entry.Encode()...)
 		bgdt.Entries = append(bgdt.Entries, entry)
 	}
 	dev.Write(int64(bgdt.StartPos), bgdtBytes)
 
 	return bgdt, nil
 }
+
+// Load reads the primary block group descriptor table of an existing
+// filesystem described by sb.
+func Load(sb *superblock.Superblock, dev *device.Device) (*Bgdt, error) {
+	bgdt := &Bgdt{}
+	bgdt.Entries = []*BgdtEntry{}
+	bgdt.StartPos = (sb.FirstBlockId + 1) * sb.BlockSize
+	bgdt.NumBgdtBlocks = sb.BgdtBlocks
+	bgdt.InodeTableBlocks = sb.InodeTableBlocks
+
+	data := dev.Read(int64(bgdt.StartPos), int64(sb.NumBlockGroups*32))
+	for bgroupNum := 0; bgroupNum < sb.NumBlockGroups; bgroupNum++ {
+		entryBytes := data[bgroupNum*32 : (bgroupNum+1)*32]
+		entry := &BgdtEntry{
+			StartPos:            bgroupNum * 32,
+			BlockBitmapLocation: int(binary.LittleEndian.Uint32(entryBytes[0:])),
+			InodeBitmapLocation: int(binary.LittleEndian.Uint32(entryBytes[4:])),
+			InodeTableLocation:  int(binary.LittleEndian.Uint32(entryBytes[8:])),
+			InodeTableBlocks:    sb.InodeTableBlocks,
+			NumFreeBlocks:       int(binary.LittleEndian.Uint16(entryBytes[12:])),
+			NumFreeInodes:       int(binary.LittleEndian.Uint16(entryBytes[14:])),
+			NumInodesAsDirs:     int(binary.LittleEndian.Uint16(entryBytes[16:])),
+			Flags:               int(binary.LittleEndian.Uint16(entryBytes[18:])),
+			ItableUnused:        int(binary.LittleEndian.Uint16(entryBytes[28:])),
+			Checksum:            int(binary.LittleEndian.Uint16(entryBytes[30:])),
+			Device:              dev,
+			Superblock:          sb,
+		}
+		if entry.BlockBitmapLocation >= sb.NumBlocks || entry.InodeTableLocation+sb.InodeTableBlocks > sb.NumBlocks {
+			return nil, errors.New("invalid block group descriptor")
+		}
+		if sb.FeaturesReadOnlyCompatible&superblock.FeatureReadOnlyCompatGdtCsum != 0 && entry.Checksum != entry.checksum() {
+			return nil, errors.New("invalid block group descriptor checksum of group " + strconv.Itoa(bgroupNum))
+		}
+		bgdt.Entries = append(bgdt.Entries, entry)
+	}
+
+	return bgdt, nil
+}
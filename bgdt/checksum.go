@@ -0,0 +1,74 @@
+package bgdt
+
+import (
+	"encoding/binary"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+// Flags of a block group, which are only used with uninit_bg.
+const (
+	// FlagInodeUninit means the inode bitmap and inode table haven't
+	// been initialized, since no inode of the group is used yet.
+	FlagInodeUninit = 0x0001
+
+	// FlagBlockUninit means the block bitmap hasn't been initialized,
+	// since only the group's own metadata blocks are used.
+	FlagBlockUninit = 0x0002
+
+	// FlagInodeZeroed means the whole inode table has been zeroed.
+	FlagInodeZeroed = 0x0004
+)
+
+// Encode returns the 32-byte on-disk block group descriptor.
+func (bgdtEntry *BgdtEntry) Encode() []byte {
+	data := make([]byte, 32)
+	binary.LittleEndian.PutUint32(data[0:], uint32(bgdtEntry.BlockBitmapLocation))
+	binary.LittleEndian.PutUint32(data[4:], uint32(bgdtEntry.InodeBitmapLocation))
+	binary.LittleEndian.PutUint32(data[8:], uint32(bgdtEntry.InodeTableLocation))
+	binary.LittleEndian.PutUint16(data[12:], uint16(bgdtEntry.NumFreeBlocks))
+	binary.LittleEndian.PutUint16(data[14:], uint16(bgdtEntry.NumFreeInodes))
+	binary.LittleEndian.PutUint16(data[16:], uint16(bgdtEntry.NumInodesAsDirs))
+	binary.LittleEndian.PutUint16(data[18:], uint16(bgdtEntry.Flags))
+	binary.LittleEndian.PutUint16(data[28:], uint16(bgdtEntry.ItableUnused))
+	binary.LittleEndian.PutUint16(data[30:], uint16(bgdtEntry.Checksum))
+	return data
+}
+
+// checksum computes the CRC16 of the volume id, the group number and the
+// descriptor up to the checksum itself.
+func (bgdtEntry *BgdtEntry) checksum() int {
+	groupNum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(groupNum, uint32(bgdtEntry.StartPos/32))
+	crc := crc16(0xFFFF, bgdtEntry.Superblock.VolumeId[:])
+	crc = crc16(crc, groupNum)
+	crc = crc16(crc, bgdtEntry.Encode()[:30])
+	return int(crc)
+}
+
+// UpdateChecksum writes the checksum of the descriptor, if the filesystem
+// has uninit_bg.
+func (bgdtEntry *BgdtEntry) UpdateChecksum() {
+	if bgdtEntry.Superblock.FeaturesReadOnlyCompatible&superblock.FeatureReadOnlyCompatGdtCsum == 0 {
+		return
+	}
+	bgdtEntry.Checksum = bgdtEntry.checksum()
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, uint16(bgdtEntry.Checksum))
+	bgdtEntry.WriteData(30, data)
+}
+
+// crc16 updates crc with data using the reflected 0x8005 polynomial.
+func crc16(crc uint16, data []byte) uint16 {
+	for _, value := range data {
+		crc ^= uint16(value)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
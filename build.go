@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/manifest"
+)
+
+func buildCommand(arguments []string) {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	var devicePath, manifestPath string
+	var quiet bool
+	flags.StringVar(&devicePath, "device", "", "The device you want to create the image on")
+	flags.StringVar(&manifestPath, "manifest", "", "A JSON manifest describing the image")
+	flags.BoolVar(&quiet, "q", false, "Don't show the progress of making the filesystem")
+	flags.Parse(arguments)
+
+	if devicePath == "" || manifestPath == "" {
+		flags.Usage()
+		return
+	}
+
+	imageManifest, err := manifest.Load(manifestPath)
+	if err != nil {
+		fmt.Printf("unable to load manifest: %v\n", err)
+		os.Exit(1)
+	}
+	if imageManifest.Time == nil {
+		creationTime, err := parseTime("")
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		if !creationTime.IsZero() {
+			imageManifest.Time = &creationTime
+		}
+	}
+	bar := &progressBar{}
+	var progress func(phase string, done, total int)
+	if !quiet {
+		progress = bar.Update
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = imageManifest.Build(ctx, devicePath, progress)
+	bar.Finish()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,215 @@
+package capability
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	XattrName = "security.capability"
+
+	Revision1 = 0x01000000
+	Revision2 = 0x02000000
+	Revision3 = 0x03000000
+
+	revisionMask   = 0xFF000000
+	flagEffective  = 0x000001
+	revision1Size  = 4 + 8
+	revision2Size  = 4 + 2*8
+	revision3Size  = revision2Size + 4
+	allPermissions = 1<<(len(Names)) - 1
+)
+
+// Names are the capabilities by number.
+var Names = [...]string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Set holds the file capabilities of a binary as bit masks indexed by
+// capability number. Effective means every permitted and inheritable
+// capability is raised on exec. Revision3 sets also store the user id
+// that is root in the user namespace the capabilities are valid in.
+type Set struct {
+	Revision    int
+	Permitted   uint64
+	Inheritable uint64
+	Effective   bool
+	RootId      int
+}
+
+func capabilityMask(name string) (uint64, error) {
+	name = strings.ToLower(name)
+	if name == "all" {
+		return allPermissions, nil
+	}
+	for i, capabilityName := range Names {
+		if name == capabilityName {
+			return 1 << i, nil
+		}
+	}
+	return 0, errors.New("unknown capability: " + name)
+}
+
+// Parse reads capabilities in the text format of cap_from_text, such as
+// "cap_net_admin,cap_net_raw+ep". Each whitespace separated clause is a
+// list of capabilities followed by one or more operators ("=", "+" or
+// "-") with the flags ("e", "i" and "p") they apply to.
+func Parse(text string) (*Set, error) {
+	var permitted, inheritable, effective uint64
+	for _, clause := range strings.Fields(text) {
+		split := strings.IndexAny(clause, "=+-")
+		if split == -1 {
+			return nil, errors.New("missing capability operator: " + clause)
+		}
+		var mask uint64
+		if names := clause[:split]; names == "" {
+			if clause[0] != '=' {
+				return nil, errors.New("missing capability names: " + clause)
+			}
+			mask = allPermissions
+		} else {
+			for _, name := range strings.Split(names, ",") {
+				capabilityMask, err := capabilityMask(name)
+				if err != nil {
+					return nil, err
+				}
+				mask |= capabilityMask
+			}
+		}
+
+		for rest := clause[split:]; rest != ""; {
+			operator := rest[0]
+			end := strings.IndexAny(rest[1:], "=+-") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			flags := rest[1:end]
+			rest = rest[end:]
+			if operator == '=' {
+				permitted &^= mask
+				inheritable &^= mask
+				effective &^= mask
+			}
+			for _, flag := range flags {
+				var target *uint64
+				switch flag {
+				case 'p':
+					target = &permitted
+				case 'i':
+					target = &inheritable
+				case 'e':
+					target = &effective
+				default:
+					return nil, errors.New("unknown capability flag: " + string(flag))
+				}
+				if operator == '-' {
+					*target &^= mask
+				} else {
+					*target |= mask
+				}
+			}
+		}
+	}
+	if effective&^(permitted|inheritable) != 0 {
+		return nil, errors.New("effective capabilities must be permitted or inheritable")
+	}
+	return &Set{
+		Revision:    Revision2,
+		Permitted:   permitted,
+		Inheritable: inheritable,
+		Effective:   effective != 0,
+	}, nil
+}
+
+// Encode returns the set as the value of a security.capability attribute.
+func (set *Set) Encode() ([]byte, error) {
+	le := binary.LittleEndian
+	var data []byte
+	switch set.Revision {
+	case Revision2:
+		data = make([]byte, revision2Size)
+	case Revision3:
+		data = make([]byte, revision3Size)
+		le.PutUint32(data[revision2Size:], uint32(set.RootId))
+	default:
+		return nil, errors.New("unsupported capability revision")
+	}
+	magic := uint32(set.Revision)
+	if set.Effective {
+		magic |= flagEffective
+	}
+	le.PutUint32(data[0:], magic)
+	le.PutUint32(data[4:], uint32(set.Permitted))
+	le.PutUint32(data[8:], uint32(set.Inheritable))
+	le.PutUint32(data[12:], uint32(set.Permitted>>32))
+	le.PutUint32(data[16:], uint32(set.Inheritable>>32))
+	return data, nil
+}
+
+func Decode(data []byte) (*Set, error) {
+	le := binary.LittleEndian
+	if len(data) < 4 {
+		return nil, errors.New("invalid capability attribute")
+	}
+	magic := le.Uint32(data)
+	set := &Set{
+		Revision:  int(magic & revisionMask),
+		Effective: magic&flagEffective != 0,
+	}
+	switch {
+	case set.Revision == Revision1 && len(data) == revision1Size:
+	case set.Revision == Revision2 && len(data) == revision2Size:
+	case set.Revision == Revision3 && len(data) == revision3Size:
+		set.RootId = int(le.Uint32(data[revision2Size:]))
+	default:
+		return nil, errors.New("invalid capability attribute")
+	}
+	set.Permitted = uint64(le.Uint32(data[4:]))
+	set.Inheritable = uint64(le.Uint32(data[8:]))
+	if set.Revision != Revision1 {
+		set.Permitted |= uint64(le.Uint32(data[12:])) << 32
+		set.Inheritable |= uint64(le.Uint32(data[16:])) << 32
+	}
+	return set, nil
+}
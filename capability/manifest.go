@@ -0,0 +1,67 @@
+package capability
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Manifest maps paths in the image to the capabilities of the file.
+type Manifest map[string]*Set
+
+// ParseManifest reads a manifest with one file per line: its path in the
+// image followed by its capabilities, such as
+//
+//	/usr/bin/ping cap_net_raw+ep
+//
+// A trailing "rootid=N" field stores the capabilities as revision 3, valid
+// in the user namespace whose root is user N.
+func ParseManifest(reader io.Reader) (Manifest, error) {
+	manifest := Manifest{}
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, errors.New("invalid capability manifest line " + strconv.Itoa(lineNum))
+		}
+		rootId := -1
+		last := fields[len(fields)-1]
+		if strings.HasPrefix(last, "rootid=") {
+			id, err := strconv.Atoi(strings.TrimPrefix(last, "rootid="))
+			if err != nil || id < 0 || int64(id) > 0xFFFFFFFF {
+				return nil, errors.New("invalid root id on capability manifest line " + strconv.Itoa(lineNum))
+			}
+			rootId = id
+			fields = fields[:len(fields)-1]
+		}
+		set, err := Parse(strings.Join(fields[1:], " "))
+		if err != nil {
+			return nil, errors.New("capability manifest line " + strconv.Itoa(lineNum) + ": " + err.Error())
+		}
+		if rootId != -1 {
+			set.Revision = Revision3
+			set.RootId = rootId
+		}
+		manifest[fields[0]] = set
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func LoadManifest(filePath string) (Manifest, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseManifest(file)
+}
@@ -1,7 +1,10 @@
 package device
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"os"
 )
 
@@ -36,6 +39,40 @@ func (device *Device) Read(position, size int64) []byte {
 	return data
 }
 
+// Zero fills size bytes at position with zeros. Chunks that already read
+// as zeros are skipped, which keeps image files sparse.
+func (device *Device) Zero(position, size int64) error {
+	if !device.Mounted {
+		panic("device isn't mounted")
+	}
+
+	const chunkSize = 1 << 20
+	zeros := make([]byte, chunkSize)
+	data := make([]byte, chunkSize)
+	for size > 0 {
+		count := int64(chunkSize)
+		if size < count {
+			count = size
+		}
+		n, err := device.ImageFile.ReadAt(data[:count], position)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if int64(n) < count || !bytes.Equal(data[:count], zeros[:count]) {
+			if _, err = device.ImageFile.WriteAt(zeros[:count], position); err != nil {
+				return err
+			}
+		}
+		position += count
+		size -= count
+	}
+	return nil
+}
+
+func (device *Device) Size() (int64, error) {
+	return device.ImageFile.Seek(0, io.SeekEnd)
+}
+
 func New(file *os.File, bytes int64) (*Device, error) {
 	_, err := file.Seek(bytes-1, 0)
 	if err != nil {
@@ -52,3 +89,17 @@ func New(file *os.File, bytes int64) (*Device, error) {
 		Mounted:   true,
 	}, nil
 }
+
+func Open(file *os.File) (*Device, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, errors.New("device is empty")
+	}
+	return &Device{
+		ImageFile: file,
+		Mounted:   true,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ScanReadOnly only reads every block, while ScanReadWrite also writes
+// test patterns to every block and reads them back, restoring the
+// original contents afterwards.
+const (
+	ScanReadOnly = iota + 1
+	ScanReadWrite
+)
+
+// testPatterns are written to every block by a read-write scan, followed
+// by a random pattern.
+var testPatterns = []byte{0xaa, 0x55, 0xff, 0x00}
+
+// Scan checks the first numBlocks blocks of the device, batchSize blocks
+// at a time, and returns the ones that failed. progress, if it isn't nil,
+// is called with the number of blocks checked so far after every batch.
+// The scan stops between batches once ctx is cancelled. Blocks are
+// dropped from the page cache before they're read, so that they're read
+// from the device itself.
+func (device *Device) Scan(ctx context.Context, mode, blockSize, numBlocks, batchSize int, progress func(done int)) ([]int, error) {
+	if !device.Mounted {
+		panic("device isn't mounted")
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if err := device.dropCache(); err != nil {
+		return nil, errors.New("unable to bypass the page cache: " + err.Error())
+	}
+
+	random := make([]byte, batchSize*blockSize)
+	rand.New(rand.NewSource(time.Now().UnixNano())).Read(random)
+	badBlocks := []int{}
+	for start := 0; start < numBlocks; start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return badBlocks, err
+		}
+		count := batchSize
+		if start+count > numBlocks {
+			count = numBlocks - start
+		}
+		badBlocks = append(badBlocks, device.scanBatch(mode, blockSize, start, count, random)...)
+		if progress != nil {
+			progress(start + count)
+		}
+	}
+	return badBlocks, nil
+}
+
+// scanBatch checks count blocks starting at block start. If any of them
+// fail, they're checked again one by one to find out which.
+func (device *Device) scanBatch(mode, blockSize, start, count int, random []byte) []int {
+	if device.checkBlocks(mode, int64(start)*int64(blockSize), count*blockSize, random) {
+		return nil
+	}
+	if count == 1 {
+		return []int{start}
+	}
+	badBlocks := []int{}
+	for bid := start; bid < start+count; bid++ {
+		badBlocks = append(badBlocks, device.scanBatch(mode, blockSize, bid, 1, random)...)
+	}
+	return badBlocks
+}
+
+// checkBlocks reports whether size bytes at position can be read and, for
+// a read-write scan, keep every test pattern written to them.
+func (device *Device) checkBlocks(mode int, position int64, size int, random []byte) bool {
+	original := make([]byte, size)
+	if device.dropCache() != nil {
+		return false
+	}
+	if _, err := device.ImageFile.ReadAt(original, position); err != nil {
+		return false
+	}
+	if mode != ScanReadWrite {
+		return true
+	}
+
+	ok := true
+	pattern := make([]byte, size)
+	readBack := make([]byte, size)
+	for index := 0; index <= len(testPatterns) && ok; index++ {
+		if index < len(testPatterns) {
+			for i := range pattern {
+				pattern[i] = testPatterns[index]
+			}
+		} else {
+			copy(pattern, random)
+		}
+		ok = device.writeAndVerify(position, pattern, readBack)
+	}
+	if _, err := device.ImageFile.WriteAt(original, position); err != nil {
+		return false
+	}
+	return ok && device.ImageFile.Sync() == nil
+}
+
+// writeAndVerify writes data at position and reads it back into readBack
+// from the device once the written pages are out of the page cache.
+func (device *Device) writeAndVerify(position int64, data, readBack []byte) bool {
+	if _, err := device.ImageFile.WriteAt(data, position); err != nil {
+		return false
+	}
+	if err := device.ImageFile.Sync(); err != nil {
+		return false
+	}
+	if err := device.dropCache(); err != nil {
+		return false
+	}
+	if _, err := device.ImageFile.ReadAt(readBack, position); err != nil {
+		return false
+	}
+	return bytes.Equal(data, readBack)
+}
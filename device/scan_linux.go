@@ -0,0 +1,11 @@
+package device
+
+import "golang.org/x/sys/unix"
+
+// dropCache evicts the device from the page cache. Only clean pages are
+// evicted, so anything written has to be synced first. The whole device
+// is dropped, since pages can be cached in folios larger than a batch,
+// which aren't evicted unless they're entirely in the range.
+func (device *Device) dropCache() error {
+	return unix.Fadvise(int(device.ImageFile.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package device
+
+import "errors"
+
+// dropCache can't bypass the page cache here, so scans would only check
+// the cache instead of the device.
+func (device *Device) dropCache() error {
+	return errors.New("unsupported on this platform")
+}
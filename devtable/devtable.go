@@ -0,0 +1,134 @@
+package devtable
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+var fileTypes = map[string]int{
+	"f": inode.ModeRegular,
+	"d": inode.ModeDirectory,
+	"c": inode.ModeCharDevice,
+	"b": inode.ModeBlockDevice,
+	"p": inode.ModeFifo,
+}
+
+// Entry is a line of a device table. Device entries with a Count create
+// Count devices named Path followed by Start, Start+1, and so on, with minor
+// numbers growing by Increment.
+type Entry struct {
+	Path      string
+	Mode      int
+	Uid       int
+	Gid       int
+	Major     int
+	Minor     int
+	Start     int
+	Increment int
+	Count     int
+}
+
+// Node is a single file described by an entry.
+type Node struct {
+	Path  string
+	Mode  int
+	Uid   int
+	Gid   int
+	Major int
+	Minor int
+}
+
+// Nodes expands an entry into the files it describes.
+func (entry *Entry) Nodes() []Node {
+	fileType := entry.Mode & inode.ModeTypeMask
+	if entry.Count == 0 || (fileType != inode.ModeCharDevice && fileType != inode.ModeBlockDevice) {
+		return []Node{{entry.Path, entry.Mode, entry.Uid, entry.Gid, entry.Major, entry.Minor}}
+	}
+	nodes := []Node{}
+	for i := 0; i < entry.Count; i++ {
+		nodes = append(nodes, Node{
+			Path:  entry.Path + strconv.Itoa(entry.Start+i),
+			Mode:  entry.Mode,
+			Uid:   entry.Uid,
+			Gid:   entry.Gid,
+			Major: entry.Major,
+			Minor: entry.Minor + i*entry.Increment,
+		})
+	}
+	return nodes
+}
+
+// Parse reads a device table in the format used by genext2fs and
+// makedevs, with one entry per line:
+//
+//	<path> <type> <mode> <uid> <gid> <major> <minor> <start> <inc> <count>
+//
+// The type is one of f (regular file), d (directory), c (character
+// device), b (block device) or p (FIFO), the mode is in octal, and unused
+// numbers can be given as "-".
+func Parse(reader io.Reader) ([]*Entry, error) {
+	entries := []*Entry{}
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 10 {
+			return nil, errors.New("invalid device table line " + strconv.Itoa(lineNum))
+		}
+		fileType, ok := fileTypes[fields[1]]
+		if !ok {
+			return nil, errors.New("invalid file type on device table line " + strconv.Itoa(lineNum) + ": " + fields[1])
+		}
+		numbers := [8]int{}
+		for i, field := range fields[2:] {
+			if field == "-" {
+				continue
+			}
+			base := 10
+			if i == 0 {
+				base = 8
+			}
+			number, err := strconv.ParseInt(field, base, 64)
+			if err != nil || number < 0 {
+				return nil, errors.New("invalid number on device table line " + strconv.Itoa(lineNum) + ": " + field)
+			}
+			numbers[i] = int(number)
+		}
+		if numbers[0]&^inode.ModePermissions != 0 {
+			return nil, errors.New("invalid mode on device table line " + strconv.Itoa(lineNum))
+		}
+		entries = append(entries, &Entry{
+			Path:      fields[0],
+			Mode:      fileType | numbers[0],
+			Uid:       numbers[1],
+			Gid:       numbers[2],
+			Major:     numbers[3],
+			Minor:     numbers[4],
+			Start:     numbers[5],
+			Increment: numbers[6],
+			Count:     numbers[7],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func Load(filePath string) ([]*Entry, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
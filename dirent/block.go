@@ -0,0 +1,146 @@
+package dirent
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Block is a directory data block.
+type Block struct {
+	Data      []byte
+	FileTypes bool
+}
+
+// NewBlock returns an empty directory block of blockSize bytes, made of a
+// single unused entry spanning the whole block.
+func NewBlock(blockSize int, fileTypes bool) *Block {
+	block := &Block{
+		Data:      make([]byte, blockSize),
+		FileTypes: fileTypes,
+	}
+	binary.LittleEndian.PutUint16(block.Data[4:], uint16(blockSize))
+	return block
+}
+
+// All returns every entry of the block in order, including unused ones
+// (InodeNum 0).
+func (block *Block) All() ([]*Dirent, error) {
+	entries := []*Dirent{}
+	for offset := 0; offset < len(block.Data); {
+		dirent, err := Decode(block.Data[offset:], block.FileTypes)
+		if err != nil {
+			return nil, err
+		}
+		dirent.Offset = offset
+		entries = append(entries, dirent)
+		offset += dirent.RecLen
+	}
+	return entries, nil
+}
+
+// Entries returns the entries of the block that are in use.
+func (block *Block) Entries() ([]*Dirent, error) {
+	all, err := block.All()
+	if err != nil {
+		return nil, err
+	}
+	entries := []*Dirent{}
+	for _, dirent := range all {
+		if dirent.InodeNum != 0 {
+			entries = append(entries, dirent)
+		}
+	}
+	return entries, nil
+}
+
+func (block *Block) Find(name string) (*Dirent, error) {
+	entries, err := block.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, dirent := range entries {
+		if dirent.Name == name {
+			return dirent, nil
+		}
+	}
+	return nil, nil
+}
+
+// Validate checks that the entries chain exactly covers the block.
+func (block *Block) Validate() error {
+	all, err := block.All()
+	if err != nil {
+		return err
+	}
+	for _, dirent := range all {
+		if dirent.InodeNum != 0 && len(dirent.Name) == 0 {
+			return errors.New("directory entry has an empty name")
+		}
+		if block.FileTypes && dirent.FileType > FileTypeSymlink {
+			return errors.New("directory entry has an invalid file type")
+		}
+	}
+	return nil
+}
+
+// Insert places dirent in the first slot with enough free space, splitting
+// the slot when it's already holding an entry. It returns false when the
+// block is full.
+func (block *Block) Insert(dirent *Dirent) (bool, error) {
+	if err := ValidateName(dirent.Name); err != nil {
+		return false, err
+	}
+	all, err := block.All()
+	if err != nil {
+		return false, err
+	}
+	needed := Length(len(dirent.Name))
+	for _, slot := range all {
+		used := 0
+		if slot.InodeNum != 0 {
+			used = Length(len(slot.Name))
+		}
+		if slot.RecLen-used < needed {
+			continue
+		}
+		if used > 0 {
+			binary.LittleEndian.PutUint16(block.Data[slot.Offset+4:], uint16(used))
+		}
+		dirent.Offset = slot.Offset + used
+		dirent.RecLen = slot.RecLen - used
+		copy(block.Data[dirent.Offset:], dirent.Encode(block.FileTypes))
+		return true, nil
+	}
+	return false, nil
+}
+
+// Remove deletes the entry at offset by merging its slot into the previous
+// entry, or by marking it unused if it's the first entry of the block.
+func (block *Block) Remove(offset int) error {
+	all, err := block.All()
+	if err != nil {
+		return err
+	}
+	for index, dirent := range all {
+		if dirent.Offset != offset {
+			continue
+		}
+		if index == 0 {
+			binary.LittleEndian.PutUint32(block.Data[offset:], 0)
+			return nil
+		}
+		previous := all[index-1]
+		binary.LittleEndian.PutUint16(block.Data[previous.Offset+4:], uint16(previous.RecLen+dirent.RecLen))
+		return nil
+	}
+	return errors.New("no directory entry at offset")
+}
+
+// Update rewrites the inode and file type of the entry at dirent.Offset,
+// keeping its name and slot.
+func (block *Block) Update(dirent *Dirent) {
+	binary.LittleEndian.PutUint32(block.Data[dirent.Offset:], uint32(dirent.InodeNum))
+	if block.FileTypes {
+		block.Data[dirent.Offset+7] = uint8(dirent.FileType)
+	}
+}
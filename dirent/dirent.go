@@ -0,0 +1,106 @@
+package dirent
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+const (
+	FileTypeUnknown     = 0
+	FileTypeRegular     = 1
+	FileTypeDirectory   = 2
+	FileTypeCharDevice  = 3
+	FileTypeBlockDevice = 4
+	FileTypeFifo        = 5
+	FileTypeSocket      = 6
+	FileTypeSymlink     = 7
+
+	MaxNameLength = 255
+)
+
+// Dirent is a single directory entry. Offset is the position of the entry
+// inside its directory block and RecLen the size of the slot it occupies,
+// which can be larger than the entry itself.
+type Dirent struct {
+	InodeNum int
+	RecLen   int
+	FileType int
+	Name     string
+	Offset   int
+}
+
+func FileTypeOf(mode int) int {
+	switch mode & inode.ModeTypeMask {
+	case inode.ModeRegular:
+		return FileTypeRegular
+	case inode.ModeDirectory:
+		return FileTypeDirectory
+	case inode.ModeCharDevice:
+		return FileTypeCharDevice
+	case inode.ModeBlockDevice:
+		return FileTypeBlockDevice
+	case inode.ModeFifo:
+		return FileTypeFifo
+	case inode.ModeSocket:
+		return FileTypeSocket
+	case inode.ModeSymlink:
+		return FileTypeSymlink
+	}
+	return FileTypeUnknown
+}
+
+// Length returns the smallest slot that fits an entry with a name of
+// nameLength bytes.
+func Length(nameLength int) int {
+	return (8 + nameLength + 3) &^ 3
+}
+
+func ValidateName(name string) error {
+	if len(name) == 0 || len(name) > MaxNameLength || strings.ContainsAny(name, "/\x00") {
+		return errors.New("invalid file name")
+	}
+	return nil
+}
+
+// Encode returns the entry without the unused space of its slot. When
+// fileTypes is false the entry is written in the revision 0 format, where
+// the file type byte is the high byte of a 16-bit name length.
+func (dirent *Dirent) Encode(fileTypes bool) []byte {
+	data := make([]byte, Length(len(dirent.Name)))
+	binary.LittleEndian.PutUint32(data[0:], uint32(dirent.InodeNum))
+	binary.LittleEndian.PutUint16(data[4:], uint16(dirent.RecLen))
+	if fileTypes {
+		data[6] = uint8(len(dirent.Name))
+		data[7] = uint8(dirent.FileType)
+	} else {
+		binary.LittleEndian.PutUint16(data[6:], uint16(len(dirent.Name)))
+	}
+	copy(data[8:], dirent.Name)
+	return data
+}
+
+func Decode(data []byte, fileTypes bool) (*Dirent, error) {
+	if len(data) < 8 {
+		return nil, errors.New("directory entry is too short")
+	}
+	dirent := &Dirent{
+		InodeNum: int(binary.LittleEndian.Uint32(data[0:])),
+		RecLen:   int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	nameLength := int(binary.LittleEndian.Uint16(data[6:]))
+	if fileTypes {
+		nameLength = int(data[6])
+		dirent.FileType = int(data[7])
+	}
+	if dirent.RecLen < 8 || dirent.RecLen%4 != 0 || dirent.RecLen > len(data) {
+		return nil, errors.New("invalid directory entry length")
+	}
+	if 8+nameLength > dirent.RecLen {
+		return nil, errors.New("directory entry name overflows its record")
+	}
+	dirent.Name = string(data[8 : 8+nameLength])
+	return dirent, nil
+}
@@ -0,0 +1,96 @@
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/acl"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/xattr"
+)
+
+func isAclIndex(index int) bool {
+	return index == xattr.IndexPosixAclAccess || index == xattr.IndexPosixAclDefault
+}
+
+func aclIndex(defaultAcl bool) int {
+	if defaultAcl {
+		return xattr.IndexPosixAclDefault
+	}
+	return xattr.IndexPosixAclAccess
+}
+
+// readAcl returns the stored access or default ACL of an inode, or nil if
+// it has none.
+func (filesystem *Filesystem) readAcl(inodeNum int, ino *inode.Inode, defaultAcl bool) ([]acl.Entry, error) {
+	attribute, err := filesystem.getXattr(inodeNum, ino, aclIndex(defaultAcl), "")
+	if err != nil || attribute == nil {
+		return nil, err
+	}
+	return acl.Decode(attribute.Value)
+}
+
+// setAcl stores the access or default ACL of an inode, or removes it if
+// entries is empty. An access ACL also sets the permission bits of the
+// file mode, and isn't stored at all if the mode already says everything
+// it does.
+func (filesystem *Filesystem) setAcl(inodeNum int, ino *inode.Inode, defaultAcl bool, entries []acl.Entry) error {
+	if defaultAcl && !ino.IsDirectory() && len(entries) > 0 {
+		return errors.New("default ACLs are only allowed on directories")
+	}
+	entries = append([]acl.Entry{}, entries...)
+	acl.Sort(entries)
+	if len(entries) > 0 {
+		if err := acl.Validate(entries); err != nil {
+			return err
+		}
+	}
+	if !defaultAcl && len(entries) > 0 {
+		ino.Mode = ino.Mode&^0o777 | acl.Mode(entries)
+		if acl.IsMinimal(entries) {
+			entries = nil
+		}
+	}
+
+	attribute := &xattr.Attribute{Index: aclIndex(defaultAcl), Value: acl.Encode(entries)}
+	found, err := filesystem.updateXattr(inodeNum, ino, attribute, len(entries) == 0)
+	if err != nil || found || len(entries) > 0 {
+		return err
+	}
+	ino.TimeLastChange = filesystem.now()
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+// GetAcl returns the access or default ACL of a file. Files without an
+// access ACL get the one matching their mode, while directories without a
+// default ACL get an empty one.
+func (filesystem *Filesystem) GetAcl(filePath string, defaultAcl bool) ([]acl.Entry, error) {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := filesystem.readAcl(inodeNum, ino, defaultAcl)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil && !defaultAcl {
+		entries = []acl.Entry{
+			{Tag: acl.TagUserObj, Perm: ino.Mode >> 6 & 7},
+			{Tag: acl.TagGroupObj, Perm: ino.Mode >> 3 & 7},
+			{Tag: acl.TagOther, Perm: ino.Mode & 7},
+		}
+	}
+	return entries, nil
+}
+
+// SetAcl replaces the access or default ACL of a file. An empty default
+// ACL removes it.
+func (filesystem *Filesystem) SetAcl(filePath string, defaultAcl bool, entries []acl.Entry) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if !defaultAcl && len(entries) == 0 {
+		return errors.New("invalid ACL")
+	}
+	return filesystem.setAcl(inodeNum, ino, defaultAcl, entries)
+}
@@ -0,0 +1,219 @@
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/bgdt"
+)
+
+func (filesystem *Filesystem) numBlocksInGroup(bgroupNum int) int {
+	sb := filesystem.Superblock
+	if bgroupNum == sb.NumBlockGroups-1 {
+		return sb.NumBlocks - (bgroupNum*sb.NumBlocksPerGroup + sb.FirstBlockId)
+	}
+	return sb.NumBlocksPerGroup
+}
+
+// findFreeBit returns the index of the first clear bit below limit, or -1.
+func findFreeBit(bitmap []byte, limit int) int {
+	for idx, val := range bitmap {
+		if idx*8 >= limit {
+			break
+		}
+		if val == 255 {
+			continue
+		}
+		for i := 0; i < 8; i++ {
+			if (1<<i)&val == 0 && idx*8+i < limit {
+				return idx*8 + i
+			}
+		}
+	}
+	return -1
+}
+
+// initBlockBitmap writes the block bitmap of a group whose BLOCK_UNINIT
+// flag is set, in which only the group's own metadata is used, and clears
+// the flag so that the bitmap can be changed.
+func (filesystem *Filesystem) initBlockBitmap(bgroupNum int) error {
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	if bgdtEntry.Flags&bgdt.FlagBlockUninit == 0 {
+		return nil
+	}
+	sb := filesystem.Superblock
+	groupStart := bgroupNum*sb.NumBlocksPerGroup + sb.FirstBlockId
+	numBlocks := filesystem.numBlocksInGroup(bgroupNum)
+	bitmap := make([]byte, sb.BlockSize)
+	for bit := 0; bit < sb.BlockSize*8; bit++ {
+		if bit >= numBlocks || filesystem.metadataAt(groupStart+bit) != "" {
+			bitmap[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	filesystem.Device.Write(int64(bgdtEntry.BlockBitmapLocation*sb.BlockSize), bitmap)
+	return bgdtEntry.SetFlags(bgdtEntry.Flags &^ bgdt.FlagBlockUninit)
+}
+
+// initInodeBitmap writes the empty inode bitmap, with only its padding
+// set, of a group whose INODE_UNINIT flag is set, and clears the flag.
+func (filesystem *Filesystem) initInodeBitmap(bgroupNum int) error {
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	if bgdtEntry.Flags&bgdt.FlagInodeUninit == 0 {
+		return nil
+	}
+	sb := filesystem.Superblock
+	bitmap := make([]byte, sb.BlockSize)
+	for bit := sb.NumInodesPerGroup; bit < sb.BlockSize*8; bit++ {
+		bitmap[bit/8] |= 1 << (bit % 8)
+	}
+	filesystem.Device.Write(int64(bgdtEntry.InodeBitmapLocation*sb.BlockSize), bitmap)
+	return bgdtEntry.SetFlags(bgdtEntry.Flags &^ bgdt.FlagInodeUninit)
+}
+
+// AllocateBlock marks a free block as used, preferring the block group
+// goalGroup, and returns its zeroed block id.
+func (filesystem *Filesystem) AllocateBlock(goalGroup int) (int, error) {
+	sb := filesystem.Superblock
+	for i := 0; i < sb.NumBlockGroups; i++ {
+		bgroupNum := (goalGroup + i) % sb.NumBlockGroups
+		bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+		if bgdtEntry.NumFreeBlocks == 0 {
+			continue
+		}
+		if err := filesystem.initBlockBitmap(bgroupNum); err != nil {
+			return 0, err
+		}
+
+		bitmapStartPos := int64(bgdtEntry.BlockBitmapLocation * sb.BlockSize)
+		bitmap := filesystem.Device.Read(bitmapStartPos, int64(sb.BlockSize))
+		bit := findFreeBit(bitmap, filesystem.numBlocksInGroup(bgroupNum))
+		if bit == -1 {
+			continue
+		}
+		filesystem.Device.Write(bitmapStartPos+int64(bit/8), []byte{bitmap[bit/8] | (1 << (bit % 8))})
+		bgdtEntry.SetNumFreeBlocks(bgdtEntry.NumFreeBlocks - 1)
+		sb.SetNumFreeBlocks(sb.NumFreeBlocks - 1)
+
+		bid := bgroupNum*sb.NumBlocksPerGroup + bit + sb.FirstBlockId
+		filesystem.Device.Write(int64(bid*sb.BlockSize), make([]byte, sb.BlockSize))
+		return bid, nil
+	}
+	return 0, errors.New("no free blocks")
+}
+
+// markBlockUsed marks a specific free block as used without zeroing it.
+func (filesystem *Filesystem) markBlockUsed(bid int) error {
+	sb := filesystem.Superblock
+	if bid < sb.FirstBlockId || bid >= sb.NumBlocks {
+		return errors.New("invalid block id")
+	}
+	bgroupNum := (bid - sb.FirstBlockId) / sb.NumBlocksPerGroup
+	bit := (bid - sb.FirstBlockId) % sb.NumBlocksPerGroup
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	if err := filesystem.initBlockBitmap(bgroupNum); err != nil {
+		return err
+	}
+
+	position := int64(bgdtEntry.BlockBitmapLocation*sb.BlockSize + bit/8)
+	val := filesystem.Device.Read(position, 1)[0]
+	if val&(1<<(bit%8)) != 0 {
+		return errors.New("block is already used")
+	}
+	filesystem.Device.Write(position, []byte{val | (1 << (bit % 8))})
+	bgdtEntry.SetNumFreeBlocks(bgdtEntry.NumFreeBlocks - 1)
+	sb.SetNumFreeBlocks(sb.NumFreeBlocks - 1)
+	return nil
+}
+
+func (filesystem *Filesystem) FreeBlock(bid int) error {
+	sb := filesystem.Superblock
+	if bid < sb.FirstBlockId || bid >= sb.NumBlocks {
+		return errors.New("invalid block id")
+	}
+	bgroupNum := (bid - sb.FirstBlockId) / sb.NumBlocksPerGroup
+	bit := (bid - sb.FirstBlockId) % sb.NumBlocksPerGroup
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	if err := filesystem.initBlockBitmap(bgroupNum); err != nil {
+		return err
+	}
+
+	position := int64(bgdtEntry.BlockBitmapLocation*sb.BlockSize + bit/8)
+	val := filesystem.Device.Read(position, 1)[0]
+	if val&(1<<(bit%8)) == 0 {
+		return errors.New("block is already free")
+	}
+	filesystem.Device.Write(position, []byte{val &^ (1 << (bit % 8))})
+	bgdtEntry.SetNumFreeBlocks(bgdtEntry.NumFreeBlocks + 1)
+	sb.SetNumFreeBlocks(sb.NumFreeBlocks + 1)
+	return nil
+}
+
+// AllocateInode marks a free inode as used, preferring the block group
+// goalGroup, and returns its zeroed inode number.
+func (filesystem *Filesystem) AllocateInode(goalGroup int, directory bool) (int, error) {
+	sb := filesystem.Superblock
+	for i := 0; i < sb.NumBlockGroups; i++ {
+		bgroupNum := (goalGroup + i) % sb.NumBlockGroups
+		bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+		if bgdtEntry.NumFreeInodes == 0 {
+			continue
+		}
+		if err := filesystem.initInodeBitmap(bgroupNum); err != nil {
+			return 0, err
+		}
+
+		bitmapStartPos := int64(bgdtEntry.InodeBitmapLocation * sb.BlockSize)
+		bitmap := filesystem.Device.Read(bitmapStartPos, int64(sb.NumInodesPerGroup/8))
+		bit := findFreeBit(bitmap, sb.NumInodesPerGroup)
+		if bit == -1 {
+			continue
+		}
+		filesystem.Device.Write(bitmapStartPos+int64(bit/8), []byte{bitmap[bit/8] | (1 << (bit % 8))})
+		bgdtEntry.SetNumFreeInodes(bgdtEntry.NumFreeInodes - 1)
+		if directory {
+			bgdtEntry.SetNumInodesAsDirs(bgdtEntry.NumInodesAsDirs + 1)
+		}
+		sb.SetNumFreeInodes(sb.NumFreeInodes - 1)
+		if sb.NumInodesPerGroup-bgdtEntry.ItableUnused <= bit {
+			bgdtEntry.SetItableUnused(sb.NumInodesPerGroup - bit - 1)
+		}
+
+		inodeNum := bgroupNum*sb.NumInodesPerGroup + bit + 1
+		offset, err := filesystem.inodeOffset(inodeNum)
+		if err != nil {
+			return 0, err
+		}
+		filesystem.Device.Write(offset, make([]byte, sb.InodeSize))
+		return inodeNum, nil
+	}
+	return 0, errors.New("no free inodes")
+}
+
+func (filesystem *Filesystem) FreeInode(inodeNum int, directory bool) error {
+	sb := filesystem.Superblock
+	if inodeNum < sb.FirstInodeIndex || inodeNum > sb.NumInodes {
+		return errors.New("invalid inode number")
+	}
+	bgroupNum := (inodeNum - 1) / sb.NumInodesPerGroup
+	bit := (inodeNum - 1) % sb.NumInodesPerGroup
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	if err := filesystem.initInodeBitmap(bgroupNum); err != nil {
+		return err
+	}
+
+	position := int64(bgdtEntry.InodeBitmapLocation*sb.BlockSize + bit/8)
+	val := filesystem.Device.Read(position, 1)[0]
+	if val&(1<<(bit%8)) == 0 {
+		return errors.New("inode is already free")
+	}
+	filesystem.Device.Write(position, []byte{val &^ (1 << (bit % 8))})
+	bgdtEntry.SetNumFreeInodes(bgdtEntry.NumFreeInodes + 1)
+	if directory {
+		bgdtEntry.SetNumInodesAsDirs(bgdtEntry.NumInodesAsDirs - 1)
+	}
+	sb.SetNumFreeInodes(sb.NumFreeInodes + 1)
+	return nil
+}
+
+func (filesystem *Filesystem) inodeGroup(inodeNum int) int {
+	return (inodeNum - 1) / filesystem.Superblock.NumInodesPerGroup
+}
@@ -0,0 +1,76 @@
+package filesystem
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+// metadataAt describes the filesystem metadata stored in block bid, or
+// returns an empty string if the block is free for data.
+func (filesystem *Filesystem) metadataAt(bid int) string {
+	sb := filesystem.Superblock
+	if bid < sb.FirstBlockId {
+		return "the boot block"
+	}
+	bgroupNum := (bid - sb.FirstBlockId) / sb.NumBlocksPerGroup
+	groupStart := bgroupNum*sb.NumBlocksPerGroup + sb.FirstBlockId
+	group := strconv.Itoa(bgroupNum)
+	for _, groupId := range sb.CopyBlockGroupIds {
+		if groupId != bgroupNum || bid > groupStart+sb.BgdtBlocks {
+			continue
+		}
+		if bgroupNum == 0 {
+			return "the primary superblock or group descriptors"
+		}
+		return "the backup superblock or group descriptors of group " + group
+	}
+	bgdtEntry := filesystem.Bgdt.Entries[bgroupNum]
+	switch {
+	case bid == bgdtEntry.BlockBitmapLocation:
+		return "the block bitmap of group " + group
+	case bid == bgdtEntry.InodeBitmapLocation:
+		return "the inode bitmap of group " + group
+	case bid >= bgdtEntry.InodeTableLocation && bid < bgdtEntry.InodeTableLocation+sb.InodeTableBlocks:
+		return "the inode table of group " + group
+	}
+	return ""
+}
+
+// markBadBlocks marks the given blocks as used and records them as the
+// data blocks of the bad blocks inode, so that nothing is ever stored in
+// them. Bad blocks holding metadata are an error, since the metadata
+// can't be moved elsewhere.
+func (filesystem *Filesystem) markBadBlocks(badBlocks []int) error {
+	sb := filesystem.Superblock
+	badBlocks = append([]int{}, badBlocks...)
+	sort.Ints(badBlocks)
+	unique := badBlocks[:0]
+	for index, bid := range badBlocks {
+		if index > 0 && bid == badBlocks[index-1] {
+			continue
+		}
+		if bid < 0 || bid >= sb.NumBlocks {
+			return errors.New("bad block " + strconv.Itoa(bid) + " is out of range")
+		}
+		if metadata := filesystem.metadataAt(bid); metadata != "" {
+			return errors.New("bad block " + strconv.Itoa(bid) + " is in " + metadata)
+		}
+		if err := filesystem.markBlockUsed(bid); err != nil {
+			return err
+		}
+		unique = append(unique, bid)
+	}
+
+	ino := filesystem.blankInode(0, filesystem.now())
+	ino.NumLinks = 0
+	for index, bid := range unique {
+		if err := filesystem.setBlock(ino, 0, index, bid); err != nil {
+			return err
+		}
+	}
+	ino.Size = int64(len(unique)) * int64(sb.BlockSize)
+	return filesystem.WriteInode(inode.BadBlocksInode, ino)
+}
@@ -0,0 +1,202 @@
+package filesystem
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+// smallFileLimit is the largest size a file may have without the
+// large_file feature.
+const smallFileLimit = 1<<31 - 1
+
+// maxFileSize returns the largest size a file can reach with the block
+// size of the filesystem, which is bounded both by the block pointers of
+// the inode and by its 32-bit count of 512-byte sectors.
+func (filesystem *Filesystem) maxFileSize() int64 {
+	sb := filesystem.Superblock
+	if sb.RevLevel == 0 {
+		return smallFileLimit
+	}
+	perBlock := int64(sb.BlockSize / 4)
+	blockSize := int64(sb.BlockSize)
+	addressable := (inode.NumDirectBlocks + perBlock + perBlock*perBlock + perBlock*perBlock*perBlock) * blockSize
+
+	metaBlocks := 1 + (1 + perBlock) + (1 + perBlock + perBlock*perBlock)
+	sectorLimit := ((1<<32-1)/(blockSize/512) - metaBlocks) * blockSize
+	if addressable > sectorLimit {
+		return sectorLimit
+	}
+	return addressable
+}
+
+// growTo checks that a file may become size bytes long, turning on the
+// large_file feature the first time a file reaches 2 GiB.
+func (filesystem *Filesystem) growTo(size int64) error {
+	if size > filesystem.maxFileSize() {
+		return errors.New("file too large")
+	}
+	sb := filesystem.Superblock
+	if size > smallFileLimit && sb.FeaturesReadOnlyCompatible&superblock.FeatureReadOnlyCompatLargeFile == 0 {
+		return sb.SetFeaturesReadOnlyCompatible(sb.FeaturesReadOnlyCompatible | superblock.FeatureReadOnlyCompatLargeFile)
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) readPointer(bid, slot int) int {
+	data := ReadBlock(filesystem.Device, filesystem.Superblock, bid, int64(slot*4), 4)
+	return int(binary.LittleEndian.Uint32(data))
+}
+
+func (filesystem *Filesystem) writePointer(bid, slot, pointer int) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(pointer))
+	WriteToBlock(filesystem.Device, filesystem.Superblock, bid, int64(slot*4), data)
+}
+
+func (filesystem *Filesystem) sectorsPerBlock() int {
+	return filesystem.Superblock.BlockSize / 512
+}
+
+// mapBlock returns the block id of the logical block index of a file. A
+// missing block (or indirect block leading to it) is allocated in
+// goalGroup when allocate is set, otherwise 0 is returned for holes.
+func (filesystem *Filesystem) mapBlock(ino *inode.Inode, goalGroup, index int, allocate bool) (int, error) {
+	return filesystem.resolveBlock(ino, goalGroup, index, allocate, 0)
+}
+
+// setBlock points the missing logical block index of a file at the
+// already used block bid, allocating the indirect blocks leading to it in
+// goalGroup.
+func (filesystem *Filesystem) setBlock(ino *inode.Inode, goalGroup, index, bid int) error {
+	_, err := filesystem.resolveBlock(ino, goalGroup, index, true, bid)
+	return err
+}
+
+// resolveBlock finds the logical block index of a file like mapBlock
+// does, except that a missing data block becomes leaf instead of a newly
+// allocated one unless leaf is 0.
+func (filesystem *Filesystem) resolveBlock(ino *inode.Inode, goalGroup, index int, allocate bool, leaf int) (int, error) {
+	if index < inode.NumDirectBlocks {
+		return filesystem.walkIndirect(ino, &ino.Blocks[index], goalGroup, 0, 0, allocate, leaf)
+	}
+	index -= inode.NumDirectBlocks
+	perBlock := filesystem.Superblock.BlockSize / 4
+	span := perBlock
+	for depth := 1; depth <= 3; depth++ {
+		if index < span {
+			return filesystem.walkIndirect(ino, &ino.Blocks[inode.IndirectBlock+depth-1], goalGroup, depth, index, allocate, leaf)
+		}
+		index -= span
+		span *= perBlock
+	}
+	return 0, errors.New("file too large")
+}
+
+func (filesystem *Filesystem) walkIndirect(ino *inode.Inode, pointer *int, goalGroup, depth, index int, allocate bool, leaf int) (int, error) {
+	if *pointer == 0 {
+		if !allocate {
+			return 0, nil
+		}
+		bid := leaf
+		if depth > 0 || leaf == 0 {
+			var err error
+			if bid, err = filesystem.AllocateBlock(goalGroup); err != nil {
+				return 0, err
+			}
+		}
+		*pointer = bid
+		ino.NumSectors += filesystem.sectorsPerBlock()
+	}
+	if depth == 0 {
+		return *pointer, nil
+	}
+
+	span := 1
+	for i := 1; i < depth; i++ {
+		span *= filesystem.Superblock.BlockSize / 4
+	}
+	slot := index / span
+	child := filesystem.readPointer(*pointer, slot)
+	bid, err := filesystem.walkIndirect(ino, &child, goalGroup, depth-1, index%span, allocate, leaf)
+	if err != nil {
+		return 0, err
+	}
+	if child != filesystem.readPointer(*pointer, slot) {
+		filesystem.writePointer(*pointer, slot, child)
+	}
+	return bid, nil
+}
+
+// truncateBlocks frees every data block of a file from the logical block
+// keep onwards, along with indirect blocks that no longer point anywhere.
+func (filesystem *Filesystem) truncateBlocks(ino *inode.Inode, keep int) error {
+	for i := 0; i < inode.NumDirectBlocks; i++ {
+		if err := filesystem.truncateIndirect(ino, &ino.Blocks[i], 0, i, keep); err != nil {
+			return err
+		}
+	}
+	perBlock := filesystem.Superblock.BlockSize / 4
+	start := inode.NumDirectBlocks
+	span := perBlock
+	for depth := 1; depth <= 3; depth++ {
+		err := filesystem.truncateIndirect(ino, &ino.Blocks[inode.IndirectBlock+depth-1], depth, start, keep)
+		if err != nil {
+			return err
+		}
+		start += span
+		span *= perBlock
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) truncateIndirect(ino *inode.Inode, pointer *int, depth, start, keep int) error {
+	if *pointer == 0 {
+		return nil
+	}
+	if depth == 0 {
+		if start < keep {
+			return nil
+		}
+		if err := filesystem.FreeBlock(*pointer); err != nil {
+			return err
+		}
+		*pointer = 0
+		ino.NumSectors -= filesystem.sectorsPerBlock()
+		return nil
+	}
+
+	perBlock := filesystem.Superblock.BlockSize / 4
+	span := 1
+	for i := 1; i < depth; i++ {
+		span *= perBlock
+	}
+	used := false
+	for slot := 0; slot < perBlock; slot++ {
+		child := filesystem.readPointer(*pointer, slot)
+		if child == 0 {
+			continue
+		}
+		childStart := start + slot*span
+		if childStart+span > keep {
+			if err := filesystem.truncateIndirect(ino, &child, depth-1, childStart, keep); err != nil {
+				return err
+			}
+			if child == 0 {
+				filesystem.writePointer(*pointer, slot, 0)
+				continue
+			}
+		}
+		used = true
+	}
+	if !used {
+		if err := filesystem.FreeBlock(*pointer); err != nil {
+			return err
+		}
+		*pointer = 0
+		ino.NumSectors -= filesystem.sectorsPerBlock()
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package filesystem
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/capability"
+	"github.com/ErrorNoInternet/mkfs.ext2/xattr"
+)
+
+// SetCapability stores the file capabilities of a regular file, or removes
+// them if set is nil.
+func (filesystem *Filesystem) SetCapability(filePath string, set *capability.Set) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if !ino.IsRegular() {
+		return errors.New("not a regular file")
+	}
+	var value []byte
+	if set != nil {
+		if value, err = set.Encode(); err != nil {
+			return err
+		}
+	}
+	attribute, err := xattr.Parse(capability.XattrName, value)
+	if err != nil {
+		return err
+	}
+	_, err = filesystem.updateXattr(inodeNum, ino, attribute, set == nil)
+	return err
+}
+
+// GetCapability returns the file capabilities of a file, or nil if it has
+// none.
+func (filesystem *Filesystem) GetCapability(filePath string) (*capability.Set, error) {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	attribute, err := xattr.Parse(capability.XattrName, nil)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := filesystem.getXattr(inodeNum, ino, attribute.Index, attribute.Name)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	return capability.Decode(stored.Value)
+}
+
+// ApplyCapabilities sets the file capabilities of every file listed in a
+// manifest.
+func (filesystem *Filesystem) ApplyCapabilities(manifest capability.Manifest) error {
+	paths := []string{}
+	for filePath := range manifest {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	for _, filePath := range paths {
+		if err := filesystem.SetCapability(filePath, manifest[filePath]); err != nil {
+			return errors.New(filePath + ": " + err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/devtable"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+// ApplyDeviceTable creates the directories, devices and FIFOs of a device
+// table that don't exist yet, and sets the mode and ownership of every
+// file it lists. Regular files have to exist already.
+func (filesystem *Filesystem) ApplyDeviceTable(entries []*devtable.Entry) error {
+	for _, entry := range entries {
+		for _, node := range entry.Nodes() {
+			if err := filesystem.applyDeviceNode(node); err != nil {
+				return errors.New(node.Path + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) applyDeviceNode(node devtable.Node) error {
+	fileType := node.Mode & inode.ModeTypeMask
+	inodeNum, ino, err := filesystem.lookup(node.Path)
+	if err == nil {
+		if ino.Mode&inode.ModeTypeMask != fileType {
+			return errors.New("file exists with a different type")
+		}
+		if fileType == inode.ModeCharDevice || fileType == inode.ModeBlockDevice {
+			ino.SetDevice(node.Major, node.Minor)
+			if err = filesystem.WriteInode(inodeNum, ino); err != nil {
+				return err
+			}
+		}
+	} else {
+		switch fileType {
+		case inode.ModeRegular:
+			return err
+		case inode.ModeDirectory:
+			err = filesystem.Mkdir(node.Path, node.Mode)
+		default:
+			err = filesystem.Mknod(node.Path, node.Mode, node.Major, node.Minor)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err = filesystem.Chmod(node.Path, node.Mode); err != nil {
+		return err
+	}
+	return filesystem.Chown(node.Path, node.Uid, node.Gid)
+}
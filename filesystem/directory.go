@@ -0,0 +1,200 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/dirent"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+func (filesystem *Filesystem) fileTypes() bool {
+	return filesystem.Superblock.FeaturesIncompatible&superblock.FeatureIncompatFiletype != 0
+}
+
+func (filesystem *Filesystem) readDirBlock(bid int) *dirent.Block {
+	return &dirent.Block{
+		Data:      ReadBlock(filesystem.Device, filesystem.Superblock, bid, 0, 0),
+		FileTypes: filesystem.fileTypes(),
+	}
+}
+
+func (filesystem *Filesystem) writeDirBlock(bid int, block *dirent.Block) {
+	WriteToBlock(filesystem.Device, filesystem.Superblock, bid, 0, block.Data)
+}
+
+// dirEntry is a directory entry along with the block holding it.
+type dirEntry struct {
+	*dirent.Dirent
+	Bid int
+}
+
+// walkDirectory calls fn for every live entry of a directory until it
+// returns true, and returns that entry.
+func (filesystem *Filesystem) walkDirectory(ino *inode.Inode, fn func(*dirEntry) bool) (*dirEntry, error) {
+	blockSize := filesystem.Superblock.BlockSize
+	for index := 0; index*blockSize < int(ino.Size); index++ {
+		bid, err := filesystem.mapBlock(ino, 0, index, false)
+		if err != nil {
+			return nil, err
+		}
+		if bid == 0 {
+			continue
+		}
+		entries, err := filesystem.readDirBlock(bid).Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if fn(&dirEntry{Dirent: entry, Bid: bid}) {
+				return &dirEntry{Dirent: entry, Bid: bid}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (filesystem *Filesystem) findEntry(ino *inode.Inode, name string) (*dirEntry, error) {
+	if isIndexed(ino) && name != "." && name != ".." {
+		return filesystem.findIndexedEntry(ino, name)
+	}
+	return filesystem.walkDirectory(ino, func(entry *dirEntry) bool {
+		return entry.Name == name
+	})
+}
+
+// addEntry links name to inodeNum in the directory dirInodeNum. Free
+// space at the end of an existing entry is reused by splitting it, and a
+// new block is only added to the directory when no entry has room. With
+// dir_index, a directory growing past one block is turned into a hashed
+// directory instead.
+func (filesystem *Filesystem) addEntry(dirInodeNum int, dirInode *inode.Inode, name string, inodeNum, fileType int) error {
+	if err := dirent.ValidateName(name); err != nil {
+		return err
+	}
+	sb := filesystem.Superblock
+	entry := &dirent.Dirent{
+		InodeNum: inodeNum,
+		FileType: fileType,
+		Name:     name,
+	}
+	if isIndexed(dirInode) {
+		return filesystem.addIndexedEntry(dirInodeNum, dirInode, entry)
+	}
+	for index := 0; index*sb.BlockSize < int(dirInode.Size); index++ {
+		bid, err := filesystem.mapBlock(dirInode, 0, index, false)
+		if err != nil {
+			return err
+		}
+		if bid == 0 {
+			continue
+		}
+		block := filesystem.readDirBlock(bid)
+		inserted, err := block.Insert(entry)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			filesystem.writeDirBlock(bid, block)
+			return nil
+		}
+	}
+
+	if filesystem.dirIndexEnabled() && int(dirInode.Size) == sb.BlockSize {
+		if err := filesystem.makeIndexed(dirInodeNum, dirInode); err != nil {
+			return err
+		}
+		return filesystem.addIndexedEntry(dirInodeNum, dirInode, entry)
+	}
+
+	_, bid, err := filesystem.appendDirBlock(dirInodeNum, dirInode)
+	if err != nil {
+		return err
+	}
+	block := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+	if _, err = block.Insert(entry); err != nil {
+		return err
+	}
+	filesystem.writeDirBlock(bid, block)
+	return filesystem.WriteInode(dirInodeNum, dirInode)
+}
+
+func (filesystem *Filesystem) removeEntry(entry *dirEntry) error {
+	block := filesystem.readDirBlock(entry.Bid)
+	if err := block.Remove(entry.Offset); err != nil {
+		return err
+	}
+	filesystem.writeDirBlock(entry.Bid, block)
+	return nil
+}
+
+func (filesystem *Filesystem) setEntryInode(entry *dirEntry, inodeNum, fileType int) {
+	block := filesystem.readDirBlock(entry.Bid)
+	entry.InodeNum = inodeNum
+	entry.FileType = fileType
+	block.Update(entry.Dirent)
+	filesystem.writeDirBlock(entry.Bid, block)
+}
+
+func (filesystem *Filesystem) isEmptyDirectory(ino *inode.Inode) (bool, error) {
+	entry, err := filesystem.walkDirectory(ino, func(entry *dirEntry) bool {
+		return entry.Name != "." && entry.Name != ".."
+	})
+	return entry == nil, err
+}
+
+func splitPath(filePath string) []string {
+	components := []string{}
+	for _, component := range strings.Split(path.Clean("/"+filePath), "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// lookup returns the inode number that filePath refers to.
+func (filesystem *Filesystem) lookup(filePath string) (int, *inode.Inode, error) {
+	inodeNum := inode.RootInode
+	ino, err := filesystem.ReadInode(inodeNum)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, component := range splitPath(filePath) {
+		if !ino.IsDirectory() {
+			return 0, nil, errors.New("not a directory")
+		}
+		entry, err := filesystem.findEntry(ino, component)
+		if err != nil {
+			return 0, nil, err
+		}
+		if entry == nil {
+			return 0, nil, errors.New("no such file or directory")
+		}
+		inodeNum = entry.InodeNum
+		ino, err = filesystem.ReadInode(inodeNum)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return inodeNum, ino, nil
+}
+
+// lookupParent returns the directory containing filePath and the name of
+// filePath inside it.
+func (filesystem *Filesystem) lookupParent(filePath string) (int, *inode.Inode, string, error) {
+	components := splitPath(filePath)
+	if len(components) == 0 {
+		return 0, nil, "", errors.New("invalid path")
+	}
+	parentNum, parent, err := filesystem.lookup(strings.Join(components[:len(components)-1], "/"))
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if !parent.IsDirectory() {
+		return 0, nil, "", errors.New("not a directory")
+	}
+	return parentNum, parent, components[len(components)-1], nil
+}
@@ -0,0 +1,66 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+// File is a regular file opened for writing. It implements io.WriteSeeker.
+type File struct {
+	filesystem *Filesystem
+	inodeNum   int
+	inode      *inode.Inode
+	position   int64
+}
+
+func (file *File) Write(data []byte) (int, error) {
+	sb := file.filesystem.Superblock
+	if err := file.filesystem.growTo(file.position + int64(len(data))); err != nil {
+		return 0, err
+	}
+	written := 0
+	goalGroup := file.filesystem.inodeGroup(file.inodeNum)
+	for written < len(data) {
+		index := int(file.position / int64(sb.BlockSize))
+		offset := int(file.position % int64(sb.BlockSize))
+		count := sb.BlockSize - offset
+		if count > len(data)-written {
+			count = len(data) - written
+		}
+		bid, err := file.filesystem.mapBlock(file.inode, goalGroup, index, true)
+		if err != nil {
+			file.filesystem.WriteInode(file.inodeNum, file.inode)
+			return written, err
+		}
+		WriteToBlock(file.filesystem.Device, sb, bid, int64(offset), data[written:written+count])
+		written += count
+		file.position += int64(count)
+		if file.position > file.inode.Size {
+			file.inode.Size = file.position
+		}
+	}
+
+	currentTime := file.filesystem.now()
+	file.inode.TimeLastModify = currentTime
+	file.inode.TimeLastChange = currentTime
+	return written, file.filesystem.WriteInode(file.inodeNum, file.inode)
+}
+
+func (file *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.position
+	case io.SeekEnd:
+		offset += file.inode.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	file.position = offset
+	return offset, nil
+}
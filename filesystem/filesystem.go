@@ -1,18 +1,19 @@
 package filesystem
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"errors"
-	"math"
 	"os"
 	"time"
 
 	"github.com/ErrorNoInternet/mkfs.ext2/bgdt"
 	"github.com/ErrorNoInternet/mkfs.ext2/device"
+	"github.com/ErrorNoInternet/mkfs.ext2/dirent"
+	"github.com/ErrorNoInternet/mkfs.ext2/htree"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/journal"
 	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
 	"github.com/google/uuid"
-	binary_pack "github.com/roman-kachanovsky/go-binary-pack/binary-pack"
 )
 
 func WriteToBlock(
@@ -23,7 +24,6 @@ func WriteToBlock(
 	data []byte,
 ) {
 	dev.Write(offset+int64(bid)*int64(sb.BlockSize), data)
-	sb.SetTimeLastWrite(time.Now().Unix())
 }
 
 func ReadBlock(
@@ -36,185 +36,415 @@ func ReadBlock(
 	if count == 0 {
 		count = sb.BlockSize
 	}
-	block := dev.Read(int64(bid*sb.BlockSize), int64(count))
+	block := dev.Read(int64(bid*sb.BlockSize)+offset, int64(count))
 	return block
 }
 
-func Make(file *os.File, blockSize, numBlocks int) error {
+// Options are the optional settings of a new filesystem.
+type Options struct {
+	// Revision is the superblock revision: 1 (dynamic) or 0 for old
+	// loaders, which doesn't allow any features or inode size but 128.
+	Revision int
+
+	DirIndex    bool
+	HashVersion int
+
+	// InodeSize is the size of each inode. Inodes larger than 128 bytes
+	// hold nanosecond timestamps and a creation time.
+	InodeSize int
+
+	// InodeRatio is the number of bytes per inode. If it's 0, every
+	// block group gets as many inodes as it has blocks.
+	InodeRatio int
+
+	// Label is the volume name, and Uuid the volume id, which is random
+	// if Uuid is nil. LastMountPath is the directory the filesystem was
+	// last mounted on, which is "/" if it's empty.
+	Label         string
+	Uuid          *uuid.UUID
+	LastMountPath string
+
+	// ReservedPercent is the percentage of blocks only ReservedUid and
+	// ReservedGid may use once the rest are full.
+	ReservedPercent float64
+	ReservedUid     int
+	ReservedGid     int
+
+	// MaxMountCount is the number of mounts after which the filesystem
+	// should be checked (or -1 to disable it), MountCount the number of
+	// mounts it starts with and CheckInterval the maximum number of
+	// seconds between checks (or 0 to disable it).
+	MaxMountCount int
+	MountCount    int
+	CheckInterval int64
+
+	// ErrorBehavior is what the kernel does when it finds an error, one of
+	// the superblock.Errors* values.
+	ErrorBehavior int
+
+	// DefaultMountOptions are the superblock.MountOpt* flags the kernel
+	// mounts the filesystem with by default, and MountOptions is a
+	// string of further options such as "errors=remount-ro".
+	DefaultMountOptions int
+	MountOptions        string
+
+	// RootUid and RootGid own the root directory.
+	RootUid int
+	RootGid int
+
+	// BadBlocks are blocks of the device that can't be used. They're
+	// recorded in the bad blocks inode and never allocated.
+	BadBlocks []int
+
+	// Scan is device.ScanReadOnly or device.ScanReadWrite to check the
+	// device for bad blocks, ScanBatchSize blocks at a time, before the
+	// filesystem is written. The blocks that fail are added to BadBlocks.
+	Scan          int
+	ScanBatchSize int
+
+	// Progress, if it isn't nil, is called as the filesystem is made with
+	// the current phase and how much of it is done.
+	Progress func(phase string, done, total int)
+
+	// Time is used for every timestamp instead of the current time, and
+	// the volume id and hash seed are derived from Seed instead of being
+	// random, unless they're empty. Together they make images
+	// reproducible.
+	Time time.Time
+	Seed string
+
+	// ExtAttr turns on ext_attr up front. Otherwise it's only turned on
+	// once an extended attribute is written.
+	ExtAttr bool
+
+	// LargeFile turns on large_file up front. Otherwise it's only turned
+	// on once a file of 2 GiB or more is written.
+	LargeFile bool
+
+	// UninitBg adds checksums to the block group descriptors and marks
+	// the groups that are still empty, so that the kernel and e2fsck can
+	// skip them. With LazyItableInit, the unused part of the inode tables
+	// isn't zeroed either, which the kernel does once it's mounted.
+	UninitBg       bool
+	LazyItableInit bool
+
+	// Journal adds an internal ext3 journal of JournalBlocks blocks, or
+	// of the default size for the filesystem if JournalBlocks is 0.
+	Journal       bool
+	JournalBlocks int
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Revision:    1,
+		DirIndex:    true,
+		HashVersion: htree.HashHalfMD4,
+		InodeSize:   128,
+
+		ReservedPercent: 5,
+		MaxMountCount:   25,
+		CheckInterval:   180 * 24 * 60 * 60,
+		ErrorBehavior:   superblock.ErrorsContinue,
+
+		ScanBatchSize: 64,
+	}
+}
+
+// progress reports how much of phase is done, if there's a callback.
+func (options *Options) progress(phase string, done, total int) {
+	if options.Progress != nil {
+		options.Progress(phase, done, total)
+	}
+}
+
+// SetFeature turns a feature on or off by its mke2fs name.
+func (options *Options) SetFeature(name string, enable bool) error {
+	switch name {
+	case "dir_index":
+		options.DirIndex = enable
+	case "ext_attr":
+		options.ExtAttr = enable
+	case "large_file":
+		options.LargeFile = enable
+	case "has_journal":
+		options.Journal = enable
+	case "uninit_bg":
+		options.UninitBg = enable
+	default:
+		return errors.New("unknown feature: " + name)
+	}
+	return nil
+}
+
+// abort gives up on making a filesystem after err. The primary superblock
+// is cleared so that the partial filesystem isn't mistaken for one.
+func abort(dev *device.Device, err error) error {
+	dev.Write(1024, make([]byte, superblock.Size))
+	dev.Unmount()
+	return err
+}
+
+// seededUuid returns a UUID derived from seed and purpose, or a random one
+// if there is no seed.
+func seededUuid(seed, purpose string) uuid.UUID {
+	if seed == "" {
+		return uuid.New()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("mkfs.ext2 "+purpose+": "+seed))
+}
+
+// inodesPerGroup returns the number of inodes of each block group for
+// options.InodeRatio, rounded up to fill whole inode table blocks. The
+// first group always has room for the reserved inodes and at least one
+// more.
+func inodesPerGroup(blockSize, numBlocks int, options *Options) int {
+	maxInodes := blockSize * 8
+	if options.InodeRatio == 0 {
+		return maxInodes
+	}
+	numGroups := (numBlocks + maxInodes - 1) / maxInodes
+	numInodes := int(int64(numBlocks) * int64(blockSize) / int64(options.InodeRatio))
+	perGroup := (numInodes + numGroups - 1) / numGroups
+	if perGroup < inode.FirstInode+1 {
+		perGroup = inode.FirstInode + 1
+	}
+	align := blockSize / options.InodeSize
+	if align < 8 {
+		align = 8
+	}
+	perGroup = (perGroup + align - 1) / align * align
+	if perGroup < align {
+		perGroup = align
+	}
+	if perGroup > maxInodes {
+		perGroup = maxInodes
+	}
+	return perGroup
+}
+
+// Make creates a filesystem of numBlocks blocks on file. Cancelling ctx
+// stops it between block groups. Then, as after any other error, the
+// device is left without a superblock so that the partial filesystem is
+// never mistaken for a complete one.
+func Make(ctx context.Context, file *os.File, blockSize, numBlocks int, options *Options) error {
 	if blockSize != 1024 && blockSize != 2048 && blockSize != 4096 {
 		return errors.New("unsupported blockSize specified")
 	}
+	if options.InodeSize < 128 || options.InodeSize > blockSize || options.InodeSize&(options.InodeSize-1) != 0 {
+		return errors.New("unsupported inodeSize specified")
+	}
+	if options.InodeRatio != 0 && options.InodeRatio < 1024 {
+		return errors.New("unsupported inodeRatio specified")
+	}
+	if options.Revision != 0 && options.Revision != 1 {
+		return errors.New("unsupported revision specified")
+	}
+	if options.Revision == 0 {
+		if options.InodeSize != 128 {
+			return errors.New("revision 0 filesystems only support 128-byte inodes")
+		}
+		if options.DirIndex || options.ExtAttr || options.LargeFile || options.Journal || options.UninitBg {
+			return errors.New("revision 0 filesystems don't support features")
+		}
+		if options.DefaultMountOptions != 0 || options.MountOptions != "" {
+			return errors.New("revision 0 filesystems don't support mount options")
+		}
+	}
+	if len(options.Label) > 16 {
+		return errors.New("volume label is longer than 16 bytes")
+	}
+	if options.ReservedPercent < 0 || options.ReservedPercent > 50 {
+		return errors.New("unsupported reserved blocks percentage specified")
+	}
+	if options.MaxMountCount < -1 || options.MaxMountCount > 0x7FFF || options.MountCount < 0 || options.MountCount > 0xFFFF {
+		return errors.New("unsupported mount count specified")
+	}
+	if options.CheckInterval < 0 || options.CheckInterval > 0xFFFFFFFF {
+		return errors.New("unsupported check interval specified")
+	}
+	if options.ErrorBehavior < superblock.ErrorsContinue || options.ErrorBehavior > superblock.ErrorsPanic {
+		return errors.New("unsupported error behavior specified")
+	}
+	if len(options.MountOptions) > 63 {
+		return errors.New("mount options are longer than 63 bytes")
+	}
+	if len(options.LastMountPath) > 64 {
+		return errors.New("last mounted directory is longer than 64 bytes")
+	}
+	if options.LazyItableInit && !options.UninitBg {
+		return errors.New("lazy inode table initialization requires uninit_bg")
+	}
+	if options.Scan != 0 && options.Scan != device.ScanReadOnly && options.Scan != device.ScanReadWrite {
+		return errors.New("unsupported bad block scan specified")
+	}
+	numInodesPerGroup := inodesPerGroup(blockSize, numBlocks, options)
 
 	dev, err := device.New(file, int64(blockSize*numBlocks))
 	if err != nil {
 		return err
 	}
+	badBlocks := options.BadBlocks
+	if options.Scan != 0 {
+		const phase = "checking for bad blocks"
+		options.progress(phase, 0, numBlocks)
+		scanned, err := dev.Scan(ctx, options.Scan, blockSize, numBlocks, options.ScanBatchSize, func(done int) {
+			options.progress(phase, done, numBlocks)
+		})
+		if err != nil {
+			dev.Unmount()
+			return err
+		}
+		badBlocks = append(append([]int{}, badBlocks...), scanned...)
+	}
 
-	currentTime := time.Now().Unix()
-	volumeIdBytes := [16]byte(uuid.New())
+	now := options.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	currentTime := now.Unix()
+	volumeIdBytes := [16]byte(seededUuid(options.Seed, "volume id"))
+	if options.Uuid != nil {
+		volumeIdBytes = *options.Uuid
+	}
 	sb, err := superblock.New(
 		1024,
 		dev,
 		0,
 		blockSize,
+		options.InodeSize,
+		numInodesPerGroup,
 		numBlocks,
 		currentTime,
 		volumeIdBytes,
+		options.Revision,
 	)
 	if err != nil {
-		return err
+		return abort(dev, err)
+	}
+	journalBlocks := 0
+	if options.Journal {
+		journalBlocks = options.JournalBlocks
+		if journalBlocks == 0 {
+			journalBlocks = journal.DefaultSize(sb.NumBlocks)
+		}
+		// The root directory and the bad blocks take up blocks before
+		// the journal is created, which can't use more than half of the
+		// rest.
+		if options.JournalBlocks != 0 && journalBlocks < journal.MinBlocks {
+			return abort(dev, errors.New("invalid journal size"))
+		}
+		if journalBlocks < journal.MinBlocks || journalBlocks > (sb.NumFreeBlocks-1-len(badBlocks))/2 {
+			return abort(dev, errors.New("filesystem too small for a journal"))
+		}
+	}
+	// bgdt.New checks the feature to lay out the descriptors, which is
+	// written to every copy of the superblock afterwards.
+	if options.UninitBg {
+		sb.FeaturesReadOnlyCompatible |= superblock.FeatureReadOnlyCompatGdtCsum
 	}
-	dt, err := bgdt.New(0, sb, dev)
+	dt, err := bgdt.New(ctx, 0, sb, dev, options.LazyItableInit, options.Progress)
 	if err != nil {
-		return err
+		return abort(dev, err)
 	}
-	if len(sb.CopyBlockGroupIds) > 0 {
-		for _, bgNum := range sb.CopyBlockGroupIds[1:] {
+	if len(sb.CopyBlockGroupIds) > 1 {
+		const phase = "writing superblocks"
+		backups := sb.CopyBlockGroupIds[1:]
+		options.progress(phase, 0, len(backups))
+		for index, bgNum := range backups {
+			if err = ctx.Err(); err != nil {
+				return abort(dev, err)
+			}
 			offset := int64((bgNum*sb.NumBlocksPerGroup + sb.FirstBlockId) * blockSize)
-			shadowSb, err := superblock.New(offset, dev, bgNum, blockSize, numBlocks, currentTime, volumeIdBytes)
+			shadowSb, err := superblock.New(offset, dev, bgNum, blockSize, options.InodeSize, numInodesPerGroup, numBlocks, currentTime, volumeIdBytes, options.Revision)
 			if err != nil {
-				return err
+				return abort(dev, err)
+			}
+			if options.UninitBg {
+				shadowSb.FeaturesReadOnlyCompatible |= superblock.FeatureReadOnlyCompatGdtCsum
+			}
+			if _, err = bgdt.New(ctx, bgNum, shadowSb, dev, options.LazyItableInit, nil); err != nil {
+				return abort(dev, err)
 			}
-			bgdt.New(bgNum, shadowSb, dev)
+			options.progress(phase, index+1, len(backups))
 		}
 	}
 
-	rootInodeOffset := dt.Entries[0].InodeTableLocation*sb.BlockSize + sb.InodeSize
-	emptyBytes := []byte("")
-	for i := 0; i < sb.InodeSize-26; i++ {
-		emptyBytes = binary.AppendVarint(emptyBytes, 0)
-	}
-	uid := 0
-	gid := 0
-	mode := 0x4000 | 0x0100 | 0x0080 | 0x0040 | 0x0020 | 0x0008 | 0x0004 | 0x0001
-	format := []string{"H", "H", "I", "I", "I", "I", "I", "H"}
-	values := []interface{}{mode, uid, 0, int(currentTime), int(currentTime), int(currentTime), 0, gid}
-	bp := new(binary_pack.BinaryPack)
-	rootInodeBytes, err := bp.Pack(format, values)
-	if err != nil {
-		return err
-	}
-	rootInodeBytes = bytes.Join([][]byte{rootInodeBytes, emptyBytes}, []byte(""))
-	dev.Write(int64(rootInodeOffset), rootInodeBytes)
-
 	sb.SaveCopies = true
-	dt.Entries[0].SetNumInodesAsDirs(dt.Entries[0].NumInodesAsDirs + 1)
-
-	bitmapSize := sb.NumBlocksPerGroup / 8
-	var bitmapStartPos int = -1
-	var groupNum int
-	var bgdtEntry *bgdt.BgdtEntry
-	for groupNum, bgdtEntry = range dt.Entries {
-		if bgdtEntry.NumFreeBlocks > 0 {
-			bitmapStartPos = bgdtEntry.BlockBitmapLocation * sb.BlockSize
-			break
+	sb.SetNumResBlocks(int(float64(sb.NumBlocks) * options.ReservedPercent / 100))
+	if err = sb.SetDefRes(options.ReservedUid, options.ReservedGid); err != nil {
+		return abort(dev, err)
+	}
+	sb.SetNumMountsMax(options.MaxMountCount)
+	sb.SetNumMountsSinceCheck(options.MountCount)
+	sb.SetTimeBetweenCheck(options.CheckInterval)
+	sb.SetErrorAction(options.ErrorBehavior)
+	if options.Revision > 0 {
+		sb.SetDefaultMountOptions(options.DefaultMountOptions)
+		if options.MountOptions != "" {
+			sb.SetMountOptions(options.MountOptions)
 		}
+		sb.SetHashSeed([16]byte(seededUuid(options.Seed, "hash seed")))
+		sb.SetDefaultHashVersion(options.HashVersion)
+		sb.SetFlags(sb.Flags | superblock.FlagUnsignedHash)
 	}
-	if bitmapStartPos == -1 {
-		return errors.New("no free blocks")
+	if options.DirIndex {
+		sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatDirIndex)
 	}
-
-	bitmapBytes := dev.Read(int64(bitmapStartPos), int64(bitmapSize))
-	if len(bitmapBytes) < bitmapSize {
-		return errors.New("invalid block bitmap")
+	if options.Label != "" {
+		sb.SetVolumeName(options.Label)
 	}
-	bitmap := []uint8(bitmapBytes)
-
-	var rootBid int = -1
-	for idx, val := range bitmap {
-		if rootBid != -1 {
-			break
-		}
-		if val != 255 {
-			for i := 0; i < 8; i++ {
-				if (1<<i)&val == 0 {
-					bid := (groupNum * sb.NumBlocksPerGroup) + (idx * 8) + i + sb.FirstBlockId
-					dev.Write(int64(bitmapStartPos+idx), []byte([]uint8{val | (1 << i)}))
-					sb.SetNumFreeBlocks(sb.NumFreeBlocks - 1)
-					bgdtEntry.SetNumFreeBlocks(bgdtEntry.NumFreeBlocks - 1)
-
-					start := bid * sb.BlockSize
-					emptyBytes := []byte("")
-					for i := 0; i < sb.BlockSize; i++ {
-						emptyBytes = binary.AppendVarint(emptyBytes, 0)
-					}
-					dev.Write(int64(start), emptyBytes)
-
-					sb.SetTimeLastWrite(time.Now().Unix())
-					rootBid = bid
-					break
-				}
-			}
-		}
+	if options.LastMountPath != "" {
+		sb.SetLastMountPath(options.LastMountPath)
 	}
-	defaultEntries1, err := bp.Pack([]string{"I", "H"}, []interface{}{2, 12})
-	if err != nil {
-		return err
+	if options.ExtAttr {
+		sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatExtAttr)
 	}
-	defaultEntries2 := []byte([]uint8{1, 2})
-	defaultEntries3, err := bp.Pack([]string{"1s"}, []interface{}{"."})
-	if err != nil {
-		return err
+	if options.LargeFile {
+		sb.SetFeaturesReadOnlyCompatible(sb.FeaturesReadOnlyCompatible | superblock.FeatureReadOnlyCompatLargeFile)
 	}
-	defaultEntries4 := []byte([]uint8{0, 0, 0})
-	defaultEntries5, err := bp.Pack([]string{"I", "H"}, []interface{}{2, blockSize - 12})
-	if err != nil {
-		return err
+	if options.UninitBg {
+		sb.SetFeaturesReadOnlyCompatible(sb.FeaturesReadOnlyCompatible | superblock.FeatureReadOnlyCompatGdtCsum)
 	}
-	defaultEntries6 := []byte([]uint8{2, 2})
-	defaultEntries7, err := bp.Pack([]string{"2s"}, []interface{}{".."})
-	if err != nil {
-		return err
+	if sb.InodeSize > 128 {
+		sb.SetExtraIsize(inode.ExtraSize, inode.ExtraSize)
 	}
-	WriteToBlock(dev, sb, rootBid, 0, bytes.Join([][]byte{defaultEntries1, defaultEntries2, defaultEntries3, defaultEntries4, defaultEntries5, defaultEntries6, defaultEntries7}, []byte("")))
 
-	inodeNum := 2
-	bgroupNum := (inodeNum - 1) / sb.NumInodesPerGroup
-	bgroupIndex := (inodeNum - 1) % sb.NumInodesPerGroup
-	bgdtEntry = dt.Entries[bgroupNum]
-	tableBid := bgdtEntry.InodeTableLocation + (bgroupIndex*sb.InodeSize)/blockSize
-	inodeTableOffset := (bgroupIndex * sb.InodeSize) % blockSize
-
-	data, err := bp.Pack([]string{"h"}, []interface{}{2})
-	if err != nil {
-		return err
+	fs := &Filesystem{Device: dev, Superblock: sb, Bgdt: dt, fixedTime: options.Time}
+	if len(badBlocks) > 0 {
+		if err = fs.markBadBlocks(badBlocks); err != nil {
+			return abort(dev, err)
+		}
 	}
-	WriteToBlock(dev, sb, tableBid, int64(inodeTableOffset+26), data)
-
-	inodeBlocks := []int{}
-	for i := 0; i < 15; i++ {
-		inodeBlocks = append(inodeBlocks, 0)
+	rootInode := fs.blankInode(inode.ModeDirectory|0755, now)
+	rootInode.Uid = options.RootUid
+	rootInode.Gid = options.RootGid
+	rootBid, err := fs.mapBlock(rootInode, 0, 0, true)
+	if err != nil {
+		return abort(dev, err)
 	}
-	size := 0
-	inodeNumDataBlocks := int(math.Ceil(float64(size) / float64(sb.BlockSize)))
-	inodeNumDirectBlocks := 12
-	if inodeNumDataBlocks < inodeNumDirectBlocks {
-		inodeBlocks[inodeNumDataBlocks] = rootBid
-		data, err = bp.Pack([]string{"I"}, []interface{}{rootBid})
-		WriteToBlock(dev, sb, tableBid, int64(inodeTableOffset+(40+inodeNumDataBlocks*4)), data)
+	rootBlock := dirent.NewBlock(blockSize, fs.fileTypes())
+	for _, name := range []string{".", ".."} {
+		_, err = rootBlock.Insert(&dirent.Dirent{
+			InodeNum: inode.RootInode,
+			FileType: dirent.FileTypeDirectory,
+			Name:     name,
+		})
 		if err != nil {
-			return err
-		}
-		inodeNumDataBlocks += 1
-		data, err = bp.Pack([]string{"I"}, []interface{}{inodeNumDataBlocks * (2 << sb.LogBlockSize)})
-		if err != nil {
-			return err
+			return abort(dev, err)
 		}
-		WriteToBlock(dev, sb, tableBid, int64(inodeTableOffset+28), data)
 	}
-
-	inodeSize := sb.BlockSize
-	data, err = bp.Pack([]string{"I"}, []interface{}{inodeSize & 0xFFFFFFFF})
-	if err != nil {
-		return err
+	fs.writeDirBlock(rootBid, rootBlock)
+	rootInode.NumLinks = 2
+	rootInode.Size = int64(blockSize)
+	if err = fs.WriteInode(inode.RootInode, rootInode); err != nil {
+		return abort(dev, err)
 	}
-	WriteToBlock(dev, sb, tableBid, int64(inodeTableOffset+4), data)
-	if sb.RevLevel > 0 && (mode&0x8000) != 0 {
-		data, err = bp.Pack([]string{"I"}, []interface{}{inodeSize >> 32})
-		if err != nil {
-			return err
+	dt.Entries[0].SetNumInodesAsDirs(dt.Entries[0].NumInodesAsDirs + 1)
+
+	if options.Journal {
+		if err = fs.createJournal(journalBlocks); err != nil {
+			return abort(dev, err)
 		}
-		WriteToBlock(dev, sb, tableBid, int64(inodeTableOffset+108), data)
 	}
 
 	dev.Unmount()
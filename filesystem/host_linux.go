@@ -0,0 +1,68 @@
+package filesystem
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/xattr"
+)
+
+// statHost returns the ownership, links and device numbers of a host file,
+// or nil if they aren't available.
+func statHost(info os.FileInfo) *hostStat {
+	stat, _ := info.Sys().(*syscall.Stat_t)
+	if stat == nil {
+		return nil
+	}
+	rdev := uint64(stat.Rdev)
+	return &hostStat{
+		Uid:        int(stat.Uid),
+		Gid:        int(stat.Gid),
+		Nlink:      uint64(stat.Nlink),
+		Dev:        uint64(stat.Dev),
+		Ino:        uint64(stat.Ino),
+		Major:      int(rdev>>8&0xFFF | rdev>>32&^0xFFF),
+		Minor:      int(rdev&0xFF | rdev>>12&^0xFF),
+		AccessTime: time.Unix(stat.Atim.Unix()),
+	}
+}
+
+// copyXattrs copies the extended attributes of a host file that can be
+// stored in ext2. Attributes in other namespaces are skipped, and so are
+// all of them on revision 0 filesystems, which can't store any.
+func (filesystem *Filesystem) copyXattrs(sourcePath, targetPath string) error {
+	if filesystem.Superblock.RevLevel == 0 {
+		return nil
+	}
+	size, err := syscall.Listxattr(sourcePath, nil)
+	if err == syscall.ENOTSUP {
+		return nil
+	} else if err != nil {
+		return err
+	} else if size == 0 {
+		return nil
+	}
+	list := make([]byte, size)
+	if size, err = syscall.Listxattr(sourcePath, list); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(list[:size]), "\x00") {
+		if _, err = xattr.Parse(name, nil); err != nil {
+			continue
+		}
+		size, err = syscall.Getxattr(sourcePath, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, size)
+		if size, err = syscall.Getxattr(sourcePath, name, value); err != nil {
+			return err
+		}
+		if err = filesystem.Setxattr(targetPath, name, value[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package filesystem
+
+import "os"
+
+// statHost isn't implemented here, so only what os.FileInfo has is copied:
+// ownership, hard links and special files are left out.
+func statHost(info os.FileInfo) *hostStat {
+	return nil
+}
+
+// copyXattrs doesn't copy anything, as extended attributes can't be read
+// from host files here.
+func (filesystem *Filesystem) copyXattrs(sourcePath, targetPath string) error {
+	return nil
+}
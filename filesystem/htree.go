@@ -0,0 +1,278 @@
+package filesystem
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/dirent"
+	"github.com/ErrorNoInternet/mkfs.ext2/htree"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+// indexFrame is an index node visited on the way from the root of a hashed
+// directory down to a leaf block.
+type indexFrame struct {
+	Bid   int
+	Node  *htree.Node
+	Index int
+}
+
+func (filesystem *Filesystem) dirIndexEnabled() bool {
+	return filesystem.Superblock.FeaturesCompatible&superblock.FeatureCompatDirIndex != 0
+}
+
+func isIndexed(ino *inode.Inode) bool {
+	return ino.Flags&inode.FlagIndex != 0
+}
+
+func (filesystem *Filesystem) hashName(name string, hashVersion int) (uint32, error) {
+	if hashVersion <= htree.HashTea && filesystem.Superblock.Flags&superblock.FlagUnsignedHash != 0 {
+		hashVersion += 3
+	}
+	hash, _, err := htree.Hash(name, hashVersion, filesystem.Superblock.HashSeed)
+	return hash, err
+}
+
+func (filesystem *Filesystem) readIndexNode(ino *inode.Inode, logical int, root bool) (int, *htree.Node, error) {
+	bid, err := filesystem.mapBlock(ino, 0, logical, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if bid == 0 {
+		return 0, nil, errors.New("hole in directory index")
+	}
+	node, err := htree.Decode(ReadBlock(filesystem.Device, filesystem.Superblock, bid, 0, 0), root)
+	return bid, node, err
+}
+
+func (filesystem *Filesystem) writeIndexNode(bid int, node *htree.Node) {
+	data := ReadBlock(filesystem.Device, filesystem.Superblock, bid, 0, 0)
+	node.Encode(data)
+	WriteToBlock(filesystem.Device, filesystem.Superblock, bid, 0, data)
+}
+
+// descendIndex walks from the root of a hashed directory to the leaf
+// block covering the hash of name.
+func (filesystem *Filesystem) descendIndex(ino *inode.Inode, name string) ([]*indexFrame, uint32, error) {
+	bid, root, err := filesystem.readIndexNode(ino, 0, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	hash, err := filesystem.hashName(name, root.HashVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	frames := []*indexFrame{{Bid: bid, Node: root, Index: root.Find(hash)}}
+	for level := 0; level < root.Levels; level++ {
+		parent := frames[len(frames)-1]
+		bid, node, err := filesystem.readIndexNode(ino, parent.Node.Entries[parent.Index].Block, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		frames = append(frames, &indexFrame{Bid: bid, Node: node, Index: node.Find(hash)})
+	}
+	return frames, hash, nil
+}
+
+func (filesystem *Filesystem) findIndexedEntry(ino *inode.Inode, name string) (*dirEntry, error) {
+	frames, hash, err := filesystem.descendIndex(ino, name)
+	if err != nil {
+		return nil, err
+	}
+	frame := frames[len(frames)-1]
+	for index := frame.Index; index < len(frame.Node.Entries); index++ {
+		if index > frame.Index && frame.Node.Entries[index].Hash != hash|1 {
+			break
+		}
+		bid, err := filesystem.mapBlock(ino, 0, frame.Node.Entries[index].Block, false)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := filesystem.readDirBlock(bid).Find(name)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return &dirEntry{Dirent: entry, Bid: bid}, nil
+		}
+	}
+	return nil, nil
+}
+
+// appendDirBlock adds a new block to the end of a directory and returns its
+// logical and physical block numbers.
+func (filesystem *Filesystem) appendDirBlock(dirInodeNum int, dirInode *inode.Inode) (int, int, error) {
+	logical := int(dirInode.Size) / filesystem.Superblock.BlockSize
+	bid, err := filesystem.mapBlock(dirInode, filesystem.inodeGroup(dirInodeNum), logical, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	dirInode.Size += int64(filesystem.Superblock.BlockSize)
+	return logical, bid, nil
+}
+
+// makeIndexed converts a single block directory into a hashed directory
+// whose root points to one leaf holding all of the existing entries.
+func (filesystem *Filesystem) makeIndexed(dirInodeNum int, dirInode *inode.Inode) error {
+	sb := filesystem.Superblock
+	rootBid, err := filesystem.mapBlock(dirInode, 0, 0, false)
+	if err != nil {
+		return err
+	}
+	entries, err := filesystem.readDirBlock(rootBid).Entries()
+	if err != nil {
+		return err
+	}
+
+	logical, leafBid, err := filesystem.appendDirBlock(dirInodeNum, dirInode)
+	if err != nil {
+		return err
+	}
+	leaf := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+	rootBlock := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+	for _, entry := range entries {
+		target := leaf
+		if entry.Name == "." || entry.Name == ".." {
+			target = rootBlock
+		}
+		if _, err = target.Insert(entry); err != nil {
+			return err
+		}
+	}
+	root := htree.NewNode(sb.BlockSize, true)
+	root.HashVersion = sb.DefaultHashVersion
+	root.Entries = []htree.Entry{{Block: logical}}
+	root.Encode(rootBlock.Data)
+	filesystem.writeDirBlock(leafBid, leaf)
+	filesystem.writeDirBlock(rootBid, rootBlock)
+
+	dirInode.Flags |= inode.FlagIndex
+	return filesystem.WriteInode(dirInodeNum, dirInode)
+}
+
+// growIndex makes room for one more entry in the lowest index node of
+// frames, either by adding a level below the root or by splitting a full
+// interior node.
+func (filesystem *Filesystem) growIndex(dirInodeNum int, dirInode *inode.Inode, frames []*indexFrame) error {
+	sb := filesystem.Superblock
+	root := frames[0].Node
+	if len(frames) == 1 {
+		if root.Levels >= htree.MaxLevels {
+			return errors.New("directory index is full")
+		}
+		logical, bid, err := filesystem.appendDirBlock(dirInodeNum, dirInode)
+		if err != nil {
+			return err
+		}
+		node := htree.NewNode(sb.BlockSize, false)
+		node.Entries = root.Entries
+		filesystem.writeIndexNode(bid, node)
+		root.Entries = []htree.Entry{{Block: logical}}
+		root.Levels += 1
+		filesystem.writeIndexNode(frames[0].Bid, root)
+		return filesystem.WriteInode(dirInodeNum, dirInode)
+	}
+
+	if len(root.Entries) >= root.Limit {
+		return errors.New("directory index is full")
+	}
+	full := frames[1].Node
+	half := len(full.Entries) / 2
+	logical, bid, err := filesystem.appendDirBlock(dirInodeNum, dirInode)
+	if err != nil {
+		return err
+	}
+	node := htree.NewNode(sb.BlockSize, false)
+	node.Entries = append([]htree.Entry{{Block: full.Entries[half].Block}}, full.Entries[half+1:]...)
+	splitHash := full.Entries[half].Hash
+	full.Entries = full.Entries[:half]
+	if err = root.Insert(frames[0].Index, htree.Entry{Hash: splitHash, Block: logical}); err != nil {
+		return err
+	}
+	filesystem.writeIndexNode(bid, node)
+	filesystem.writeIndexNode(frames[1].Bid, full)
+	filesystem.writeIndexNode(frames[0].Bid, root)
+	return filesystem.WriteInode(dirInodeNum, dirInode)
+}
+
+// addIndexedEntry inserts an entry into the leaf of a hashed directory that
+// covers its hash, splitting the leaf in two by hash when it's full.
+func (filesystem *Filesystem) addIndexedEntry(dirInodeNum int, dirInode *inode.Inode, entry *dirent.Dirent) error {
+	sb := filesystem.Superblock
+	for {
+		frames, _, err := filesystem.descendIndex(dirInode, entry.Name)
+		if err != nil {
+			return err
+		}
+		frame := frames[len(frames)-1]
+		leafBid, err := filesystem.mapBlock(dirInode, 0, frame.Node.Entries[frame.Index].Block, false)
+		if err != nil {
+			return err
+		}
+		leaf := filesystem.readDirBlock(leafBid)
+		inserted, err := leaf.Insert(entry)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			filesystem.writeDirBlock(leafBid, leaf)
+			return nil
+		}
+
+		if len(frame.Node.Entries) >= frame.Node.Limit {
+			if err = filesystem.growIndex(dirInodeNum, dirInode, frames); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entries, err := leaf.Entries()
+		if err != nil {
+			return err
+		}
+		if len(entries) < 2 {
+			return errors.New("directory entry doesn't fit in a block")
+		}
+		hashes := map[*dirent.Dirent]uint32{}
+		for _, leafEntry := range entries {
+			hashes[leafEntry], err = filesystem.hashName(leafEntry.Name, frames[0].Node.HashVersion)
+			if err != nil {
+				return err
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return hashes[entries[i]] < hashes[entries[j]]
+		})
+		half := len(entries) / 2
+		splitHash := hashes[entries[half]]
+		if splitHash == hashes[entries[half-1]] {
+			splitHash |= 1
+		}
+
+		logical, newBid, err := filesystem.appendDirBlock(dirInodeNum, dirInode)
+		if err != nil {
+			return err
+		}
+		lower := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+		upper := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+		for index, leafEntry := range entries {
+			target := lower
+			if index >= half {
+				target = upper
+			}
+			if _, err = target.Insert(leafEntry); err != nil {
+				return err
+			}
+		}
+		if err = frame.Node.Insert(frame.Index, htree.Entry{Hash: splitHash, Block: logical}); err != nil {
+			return err
+		}
+		filesystem.writeDirBlock(leafBid, lower)
+		filesystem.writeDirBlock(newBid, upper)
+		filesystem.writeIndexNode(frame.Bid, frame.Node)
+		if err = filesystem.WriteInode(dirInodeNum, dirInode); err != nil {
+			return err
+		}
+	}
+}
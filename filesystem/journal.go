@@ -0,0 +1,131 @@
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/journal"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+// createJournal allocates numBlocks blocks for the internal journal inode,
+// starting from the middle of the filesystem, and writes an empty JBD2
+// superblock to its first block.
+func (filesystem *Filesystem) createJournal(numBlocks int) error {
+	sb := filesystem.Superblock
+	if numBlocks < journal.MinBlocks || numBlocks > sb.NumFreeBlocks/2 {
+		return errors.New("invalid journal size")
+	}
+
+	ino := filesystem.blankInode(inode.ModeRegular|0600, filesystem.now())
+	goalGroup := (sb.NumBlocks - sb.FirstBlockId) / 2 / sb.NumBlocksPerGroup
+	for index := 0; index < numBlocks; index++ {
+		if _, err := filesystem.mapBlock(ino, goalGroup, index, true); err != nil {
+			return err
+		}
+	}
+	ino.Size = int64(numBlocks) * int64(sb.BlockSize)
+	if err := filesystem.WriteInode(journal.Inode, ino); err != nil {
+		return err
+	}
+
+	jsb := journal.NewSuperblock(sb.BlockSize, numBlocks, sb.VolumeId)
+	WriteToBlock(filesystem.Device, sb, ino.Blocks[0], 0, jsb.Encode())
+
+	journalBlocks := [17]int{}
+	copy(journalBlocks[:], ino.Blocks[:])
+	journalBlocks[15] = int(ino.Size >> 32)
+	journalBlocks[16] = int(ino.Size & 0xFFFFFFFF)
+	if err := sb.SetJournalBlocks(journalBlocks); err != nil {
+		return err
+	}
+	if err := sb.SetJournalInode(journal.Inode); err != nil {
+		return err
+	}
+	return sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatHasJournal)
+}
+
+// removeJournal frees the blocks of the internal journal, clears its
+// inode and turns has_journal off. The journal has to be empty, which it
+// is once the filesystem has been opened.
+func (filesystem *Filesystem) removeJournal() error {
+	sb := filesystem.Superblock
+	if sb.FeaturesIncompatible&superblock.FeatureIncompatRecover != 0 {
+		return errors.New("journal needs recovery")
+	}
+	if sb.JournalInode == 0 {
+		return errors.New("external journals aren't supported")
+	}
+	ino, err := filesystem.ReadInode(sb.JournalInode)
+	if err != nil {
+		return err
+	}
+	if err = filesystem.truncateBlocks(ino, 0); err != nil {
+		return err
+	}
+	offset, err := filesystem.inodeOffset(sb.JournalInode)
+	if err != nil {
+		return err
+	}
+	filesystem.Device.Write(offset, make([]byte, sb.InodeSize))
+
+	if err = sb.SetJournalBlocks([17]int{}); err != nil {
+		return err
+	}
+	if err = sb.SetJournalInode(0); err != nil {
+		return err
+	}
+	return sb.SetFeaturesCompatible(sb.FeaturesCompatible &^ superblock.FeatureCompatHasJournal)
+}
+
+// RecoverJournal replays the committed transactions of the internal
+// journal onto the filesystem and clears needs_recovery. The superblock
+// and the block group descriptors may be rewritten by the replay, so the
+// filesystem should be loaded again afterwards.
+func (filesystem *Filesystem) RecoverJournal() (int, error) {
+	sb := filesystem.Superblock
+	if sb.FeaturesCompatible&superblock.FeatureCompatHasJournal == 0 {
+		if sb.FeaturesIncompatible&superblock.FeatureIncompatRecover != 0 {
+			return 0, errors.New("filesystem needs recovery but has no journal")
+		}
+		return 0, nil
+	}
+	if sb.JournalInode == 0 {
+		return 0, errors.New("external journals aren't supported")
+	}
+
+	ino, err := filesystem.ReadInode(sb.JournalInode)
+	if err != nil {
+		return 0, err
+	}
+	blocks := make([]int, ino.Size/int64(sb.BlockSize))
+	for index := range blocks {
+		blocks[index], err = filesystem.mapBlock(ino, 0, index, false)
+		if err != nil {
+			return 0, err
+		}
+		if blocks[index] == 0 {
+			return 0, errors.New("journal inode has holes")
+		}
+	}
+	jnl, err := journal.Load(filesystem.Device, sb.BlockSize, blocks)
+	if err != nil {
+		return 0, err
+	}
+	replayed, err := jnl.Replay()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered, err := superblock.Load(filesystem.Device)
+	if err != nil {
+		return 0, err
+	}
+	if recovered.FeaturesIncompatible&superblock.FeatureIncompatRecover != 0 {
+		err = recovered.SetFeaturesIncompatible(recovered.FeaturesIncompatible &^ superblock.FeatureIncompatRecover)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return replayed, nil
+}
@@ -0,0 +1,141 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/bgdt"
+	"github.com/ErrorNoInternet/mkfs.ext2/device"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+)
+
+// Filesystem is a handle to an existing ext2 image that can be modified.
+// Every change keeps the bitmaps, the block group descriptors and the
+// superblock counters (including their backup copies) up to date.
+type Filesystem struct {
+	Device     *device.Device
+	Superblock *superblock.Superblock
+	Bgdt       *bgdt.Bgdt
+
+	// fixedTime replaces the current time in every timestamp written
+	// through this handle unless it's zero.
+	fixedTime time.Time
+
+	// xattrBlocks indexes the attribute blocks written through this handle
+	// by their hash, so that inodes with the same attributes share them.
+	xattrBlocks map[uint32][]int
+}
+
+func load(dev *device.Device) (*Filesystem, error) {
+	sb, err := superblock.Load(dev)
+	if err != nil {
+		return nil, err
+	}
+	dt, err := bgdt.Load(sb, dev)
+	if err != nil {
+		return nil, err
+	}
+	return &Filesystem{
+		Device:     dev,
+		Superblock: sb,
+		Bgdt:       dt,
+	}, nil
+}
+
+// Open loads an existing filesystem for reading and writing. A journal
+// that needs recovery is replayed first.
+func Open(file *os.File) (*Filesystem, error) {
+	dev, err := device.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	filesystem, err := load(dev)
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.Superblock.FeaturesIncompatible&superblock.FeatureIncompatRecover != 0 {
+		if _, err = filesystem.RecoverJournal(); err != nil {
+			return nil, err
+		}
+		filesystem, err = load(dev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filesystem.Superblock.FeaturesIncompatible&^superblock.FeatureIncompatFiletype != 0 {
+		return nil, errors.New("filesystem has unsupported incompatible features")
+	}
+	readOnlyCompatible := superblock.FeatureReadOnlyCompatSparseSuper | superblock.FeatureReadOnlyCompatLargeFile | superblock.FeatureReadOnlyCompatGdtCsum
+	if filesystem.Superblock.FeaturesReadOnlyCompatible&^readOnlyCompatible != 0 {
+		return nil, errors.New("filesystem has unsupported read-only compatible features")
+	}
+	return filesystem, nil
+}
+
+// Replay recovers the journal of the filesystem in file without making
+// any other change, and returns the number of replayed transactions.
+func Replay(file *os.File) (int, error) {
+	dev, err := device.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer dev.Unmount()
+	filesystem, err := load(dev)
+	if err != nil {
+		return 0, err
+	}
+	return filesystem.RecoverJournal()
+}
+
+func (filesystem *Filesystem) Close() {
+	filesystem.Superblock.SetTimeLastWrite(filesystem.now().Unix())
+	filesystem.Device.Unmount()
+}
+
+// SetTime makes every timestamp written through the handle currentTime
+// instead of the current time, so that the same changes always give the
+// same image.
+func (filesystem *Filesystem) SetTime(currentTime time.Time) {
+	filesystem.fixedTime = currentTime
+}
+
+func (filesystem *Filesystem) now() time.Time {
+	if !filesystem.fixedTime.IsZero() {
+		return filesystem.fixedTime
+	}
+	return time.Now()
+}
+
+func (filesystem *Filesystem) inodeOffset(inodeNum int) (int64, error) {
+	sb := filesystem.Superblock
+	if inodeNum < 1 || inodeNum > sb.NumInodes {
+		return 0, errors.New("invalid inode number")
+	}
+	bgroupNum := (inodeNum - 1) / sb.NumInodesPerGroup
+	bgroupIndex := (inodeNum - 1) % sb.NumInodesPerGroup
+	tableStart := filesystem.Bgdt.Entries[bgroupNum].InodeTableLocation * sb.BlockSize
+	return int64(tableStart + bgroupIndex*sb.InodeSize), nil
+}
+
+func (filesystem *Filesystem) ReadInode(inodeNum int) (*inode.Inode, error) {
+	offset, err := filesystem.inodeOffset(inodeNum)
+	if err != nil {
+		return nil, err
+	}
+	return inode.Decode(filesystem.Device.Read(offset, int64(filesystem.Superblock.InodeSize)))
+}
+
+func (filesystem *Filesystem) WriteInode(inodeNum int, ino *inode.Inode) error {
+	offset, err := filesystem.inodeOffset(inodeNum)
+	if err != nil {
+		return err
+	}
+	data, err := ino.Encode()
+	if err != nil {
+		return err
+	}
+	filesystem.Device.Write(offset, data)
+	return nil
+}
@@ -0,0 +1,507 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/acl"
+	"github.com/ErrorNoInternet/mkfs.ext2/dirent"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+// newInode allocates and writes an inode of the given mode near its parent
+// directory.
+func (filesystem *Filesystem) newInode(parentNum, mode int) (int, *inode.Inode, error) {
+	directory := mode&inode.ModeTypeMask == inode.ModeDirectory
+	inodeNum, err := filesystem.AllocateInode(filesystem.inodeGroup(parentNum), directory)
+	if err != nil {
+		return 0, nil, err
+	}
+	ino := filesystem.blankInode(mode, filesystem.now())
+	return inodeNum, ino, filesystem.WriteInode(inodeNum, ino)
+}
+
+// blankInode returns an inode of the given mode with a single link, all
+// of its timestamps set to currentTime and, on filesystems with large
+// inodes, room for the extra fields.
+func (filesystem *Filesystem) blankInode(mode int, currentTime time.Time) *inode.Inode {
+	ino := &inode.Inode{
+		Mode:           mode,
+		NumLinks:       1,
+		TimeLastAccess: currentTime,
+		TimeLastChange: currentTime,
+		TimeLastModify: currentTime,
+		TimeCreation:   currentTime,
+	}
+	if filesystem.Superblock.InodeSize > 128 {
+		ino.ExtraSize = filesystem.Superblock.WantExtraIsize
+	}
+	return ino
+}
+
+// create adds a new inode named by filePath, failing if it already exists.
+func (filesystem *Filesystem) create(filePath string, mode int) (int, *inode.Inode, error) {
+	parentNum, parent, name, err := filesystem.lookupParent(filePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	existing, err := filesystem.findEntry(parent, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	if existing != nil {
+		return 0, nil, errors.New("file already exists")
+	}
+
+	inodeNum, ino, err := filesystem.newInode(parentNum, mode)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = filesystem.addEntry(parentNum, parent, name, inodeNum, dirent.FileTypeOf(mode))
+	if err != nil {
+		filesystem.FreeInode(inodeNum, ino.IsDirectory())
+		return 0, nil, err
+	}
+	currentTime := filesystem.now()
+	parent.TimeLastModify = currentTime
+	parent.TimeLastChange = currentTime
+	return inodeNum, ino, filesystem.WriteInode(parentNum, parent)
+}
+
+// discardCreated undoes create for a new inode that couldn't be set up:
+// it removes the entry from the parent and frees the inode along with any
+// blocks it was already given.
+func (filesystem *Filesystem) discardCreated(parent *inode.Inode, name string, inodeNum int, ino *inode.Inode) {
+	if entry, err := filesystem.findEntry(parent, name); err == nil && entry != nil {
+		filesystem.removeEntry(entry)
+	}
+	filesystem.truncateBlocks(ino, 0)
+	ino.NumLinks = 0
+	ino.TimeDeletion = filesystem.now().Unix()
+	filesystem.WriteInode(inodeNum, ino)
+	filesystem.FreeInode(inodeNum, ino.IsDirectory())
+}
+
+// Create creates or truncates the regular file named by filePath and
+// returns it for writing.
+func (filesystem *Filesystem) Create(filePath string, mode int) (*File, error) {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err == nil {
+		if !ino.IsRegular() {
+			return nil, errors.New("not a regular file")
+		}
+		if err = filesystem.truncate(inodeNum, ino, 0); err != nil {
+			return nil, err
+		}
+	} else {
+		inodeNum, ino, err = filesystem.create(filePath, inode.ModeRegular|mode&inode.ModePermissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &File{
+		filesystem: filesystem,
+		inodeNum:   inodeNum,
+		inode:      ino,
+	}, nil
+}
+
+func (filesystem *Filesystem) Mkdir(filePath string, mode int) error {
+	inodeNum, ino, err := filesystem.create(filePath, inode.ModeDirectory|mode&inode.ModePermissions)
+	if err != nil {
+		return err
+	}
+	parentNum, parent, name, err := filesystem.lookupParent(filePath)
+	if err != nil {
+		return err
+	}
+
+	sb := filesystem.Superblock
+	bid, err := filesystem.mapBlock(ino, filesystem.inodeGroup(inodeNum), 0, true)
+	if err != nil {
+		filesystem.discardCreated(parent, name, inodeNum, ino)
+		return err
+	}
+	block := dirent.NewBlock(sb.BlockSize, filesystem.fileTypes())
+	for _, entry := range []*dirent.Dirent{
+		{InodeNum: inodeNum, FileType: dirent.FileTypeDirectory, Name: "."},
+		{InodeNum: parentNum, FileType: dirent.FileTypeDirectory, Name: ".."},
+	} {
+		if _, err = block.Insert(entry); err != nil {
+			filesystem.discardCreated(parent, name, inodeNum, ino)
+			return err
+		}
+	}
+	filesystem.writeDirBlock(bid, block)
+	ino.Size = int64(sb.BlockSize)
+	ino.NumLinks = 2
+	if err = filesystem.WriteInode(inodeNum, ino); err != nil {
+		return err
+	}
+
+	parent.NumLinks += 1
+	return filesystem.WriteInode(parentNum, parent)
+}
+
+// MkdirAll creates a directory along with any missing parents. Existing
+// directories are left as they are.
+func (filesystem *Filesystem) MkdirAll(filePath string, mode int) error {
+	current := "/"
+	for _, name := range splitPath(filePath) {
+		current = path.Join(current, name)
+		_, ino, err := filesystem.lookup(current)
+		if err != nil {
+			if err = filesystem.Mkdir(current, mode); err != nil {
+				return err
+			}
+		} else if !ino.IsDirectory() {
+			return errors.New("not a directory")
+		}
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) Symlink(target, filePath string) error {
+	if len(target) == 0 || len(target) >= filesystem.Superblock.BlockSize {
+		return errors.New("invalid symlink target")
+	}
+	inodeNum, ino, err := filesystem.create(filePath, inode.ModeSymlink|0777)
+	if err != nil {
+		return err
+	}
+
+	ino.Size = int64(len(target))
+	if len(target) < 60 {
+		for i := 0; i < len(target); i += 4 {
+			chunk := make([]byte, 4)
+			copy(chunk, target[i:])
+			ino.Blocks[i/4] = int(chunk[0]) | int(chunk[1])<<8 | int(chunk[2])<<16 | int(chunk[3])<<24
+		}
+		return filesystem.WriteInode(inodeNum, ino)
+	}
+	bid, err := filesystem.mapBlock(ino, filesystem.inodeGroup(inodeNum), 0, true)
+	if err != nil {
+		return err
+	}
+	WriteToBlock(filesystem.Device, filesystem.Superblock, bid, 0, []byte(target))
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+// Mknod creates a character or block device, a FIFO or a socket, with the
+// type given by mode. The device numbers are only used for devices.
+func (filesystem *Filesystem) Mknod(filePath string, mode, major, minor int) error {
+	device := false
+	switch mode & inode.ModeTypeMask {
+	case inode.ModeCharDevice, inode.ModeBlockDevice:
+		device = true
+	case inode.ModeFifo, inode.ModeSocket:
+	default:
+		return errors.New("invalid special file type")
+	}
+	if major < 0 || major > 0xFFF || minor < 0 || minor > 0xFFFFF {
+		return errors.New("invalid device number")
+	}
+	inodeNum, ino, err := filesystem.create(filePath, mode&(inode.ModeTypeMask|inode.ModePermissions))
+	if err != nil {
+		return err
+	}
+	if device {
+		ino.SetDevice(major, minor)
+	}
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+func (filesystem *Filesystem) Link(oldPath, newPath string) error {
+	inodeNum, ino, err := filesystem.lookup(oldPath)
+	if err != nil {
+		return err
+	}
+	if ino.IsDirectory() {
+		return errors.New("hard links to directories aren't allowed")
+	}
+	if ino.NumLinks >= 65000 {
+		return errors.New("too many links")
+	}
+	parentNum, parent, name, err := filesystem.lookupParent(newPath)
+	if err != nil {
+		return err
+	}
+	existing, err := filesystem.findEntry(parent, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.New("file already exists")
+	}
+	if err = filesystem.addEntry(parentNum, parent, name, inodeNum, dirent.FileTypeOf(ino.Mode)); err != nil {
+		return err
+	}
+
+	currentTime := filesystem.now()
+	parent.TimeLastModify = currentTime
+	parent.TimeLastChange = currentTime
+	if err = filesystem.WriteInode(parentNum, parent); err != nil {
+		return err
+	}
+	ino.NumLinks += 1
+	ino.TimeLastChange = currentTime
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+// unlink removes a directory entry and drops the link it held, releasing
+// the inode and its blocks once nothing refers to it anymore.
+func (filesystem *Filesystem) unlink(parentNum int, parent *inode.Inode, entry *dirEntry) error {
+	ino, err := filesystem.ReadInode(entry.InodeNum)
+	if err != nil {
+		return err
+	}
+	if ino.IsDirectory() {
+		empty, err := filesystem.isEmptyDirectory(ino)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return errors.New("directory not empty")
+		}
+	}
+	if err = filesystem.removeEntry(entry); err != nil {
+		return err
+	}
+
+	currentTime := filesystem.now()
+	parent.TimeLastModify = currentTime
+	parent.TimeLastChange = currentTime
+	ino.TimeLastChange = currentTime
+	ino.NumLinks -= 1
+	if ino.IsDirectory() {
+		parent.NumLinks -= 1
+		ino.NumLinks = 0
+	}
+	if err = filesystem.WriteInode(parentNum, parent); err != nil {
+		return err
+	}
+	if ino.NumLinks > 0 {
+		return filesystem.WriteInode(entry.InodeNum, ino)
+	}
+
+	if ino.HasDataBlocks() {
+		if err = filesystem.truncateBlocks(ino, 0); err != nil {
+			return err
+		}
+	}
+	if err = filesystem.releaseXattrBlock(ino); err != nil {
+		return err
+	}
+	ino.TimeDeletion = currentTime.Unix()
+	if err = filesystem.WriteInode(entry.InodeNum, ino); err != nil {
+		return err
+	}
+	return filesystem.FreeInode(entry.InodeNum, ino.IsDirectory())
+}
+
+func (filesystem *Filesystem) Remove(filePath string) error {
+	parentNum, parent, name, err := filesystem.lookupParent(filePath)
+	if err != nil {
+		return err
+	}
+	if name == "." || name == ".." {
+		return errors.New("invalid path")
+	}
+	entry, err := filesystem.findEntry(parent, name)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return errors.New("no such file or directory")
+	}
+	return filesystem.unlink(parentNum, parent, entry)
+}
+
+func (filesystem *Filesystem) Rename(oldPath, newPath string) error {
+	oldParentNum, oldParent, oldName, err := filesystem.lookupParent(oldPath)
+	if err != nil {
+		return err
+	}
+	oldEntry, err := filesystem.findEntry(oldParent, oldName)
+	if err != nil {
+		return err
+	}
+	if oldEntry == nil {
+		return errors.New("no such file or directory")
+	}
+	ino, err := filesystem.ReadInode(oldEntry.InodeNum)
+	if err != nil {
+		return err
+	}
+	newParentNum, newParent, newName, err := filesystem.lookupParent(newPath)
+	if err != nil {
+		return err
+	}
+
+	if ino.IsDirectory() {
+		ancestor := newParentNum
+		for ancestor != inode.RootInode {
+			if ancestor == oldEntry.InodeNum {
+				return errors.New("can't move a directory into itself")
+			}
+			ancestorInode, err := filesystem.ReadInode(ancestor)
+			if err != nil {
+				return err
+			}
+			dotDot, err := filesystem.findEntry(ancestorInode, "..")
+			if err != nil || dotDot == nil {
+				return errors.New("corrupted directory")
+			}
+			ancestor = dotDot.InodeNum
+		}
+	}
+
+	newEntry, err := filesystem.findEntry(newParent, newName)
+	if err != nil {
+		return err
+	}
+	if newEntry != nil {
+		if newEntry.InodeNum == oldEntry.InodeNum {
+			return nil
+		}
+		target, err := filesystem.ReadInode(newEntry.InodeNum)
+		if err != nil {
+			return err
+		}
+		if target.IsDirectory() != ino.IsDirectory() {
+			return errors.New("can't replace a directory with a non-directory")
+		}
+		if err = filesystem.unlink(newParentNum, newParent, newEntry); err != nil {
+			return err
+		}
+		if newParentNum == oldParentNum {
+			oldParent = newParent
+		}
+	}
+
+	if err = filesystem.addEntry(newParentNum, newParent, newName, oldEntry.InodeNum, dirent.FileTypeOf(ino.Mode)); err != nil {
+		return err
+	}
+	if newParentNum == oldParentNum {
+		oldParent = newParent
+	}
+	oldEntry, err = filesystem.findEntry(oldParent, oldName)
+	if err != nil {
+		return err
+	}
+	if err = filesystem.removeEntry(oldEntry); err != nil {
+		return err
+	}
+
+	currentTime := filesystem.now()
+	ino.TimeLastChange = currentTime
+	if ino.IsDirectory() && newParentNum != oldParentNum {
+		dotDot, err := filesystem.findEntry(ino, "..")
+		if err != nil || dotDot == nil {
+			return errors.New("corrupted directory")
+		}
+		filesystem.setEntryInode(dotDot, newParentNum, dirent.FileTypeDirectory)
+		oldParent.NumLinks -= 1
+		newParent.NumLinks += 1
+	}
+	for _, parent := range []*inode.Inode{oldParent, newParent} {
+		parent.TimeLastModify = currentTime
+		parent.TimeLastChange = currentTime
+	}
+	if err = filesystem.WriteInode(oldParentNum, oldParent); err != nil {
+		return err
+	}
+	if err = filesystem.WriteInode(newParentNum, newParent); err != nil {
+		return err
+	}
+	return filesystem.WriteInode(oldEntry.InodeNum, ino)
+}
+
+func (filesystem *Filesystem) Chmod(filePath string, mode int) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	ino.Mode = ino.Mode&inode.ModeTypeMask | mode&inode.ModePermissions
+	ino.TimeLastChange = filesystem.now()
+	entries, err := filesystem.readAcl(inodeNum, ino, false)
+	if err != nil {
+		return err
+	}
+	if entries != nil {
+		acl.Chmod(entries, mode)
+		return filesystem.setAcl(inodeNum, ino, false, entries)
+	}
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+func (filesystem *Filesystem) Chown(filePath string, uid, gid int) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if uid >= 0 {
+		ino.Uid = uid
+	}
+	if gid >= 0 {
+		ino.Gid = gid
+	}
+	ino.TimeLastChange = filesystem.now()
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+func (filesystem *Filesystem) Chtimes(filePath string, accessTime, modifyTime time.Time) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	ino.TimeLastAccess = accessTime
+	ino.TimeLastModify = modifyTime
+	ino.TimeLastChange = filesystem.now()
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+// truncate changes the size of a file, freeing blocks past the new end.
+// Growing a file leaves a hole that reads back as zeros.
+func (filesystem *Filesystem) truncate(inodeNum int, ino *inode.Inode, size int64) error {
+	if size < 0 {
+		return errors.New("invalid file size")
+	}
+	if err := filesystem.growTo(size); err != nil {
+		return err
+	}
+	blockSize := int64(filesystem.Superblock.BlockSize)
+	if size < ino.Size {
+		keep := int((size + blockSize - 1) / blockSize)
+		if err := filesystem.truncateBlocks(ino, keep); err != nil {
+			return err
+		}
+		if size%blockSize != 0 {
+			bid, err := filesystem.mapBlock(ino, 0, int(size/blockSize), false)
+			if err != nil {
+				return err
+			}
+			if bid != 0 {
+				tail := make([]byte, blockSize-size%blockSize)
+				WriteToBlock(filesystem.Device, filesystem.Superblock, bid, size%blockSize, tail)
+			}
+		}
+	}
+	ino.Size = size
+	currentTime := filesystem.now()
+	ino.TimeLastModify = currentTime
+	ino.TimeLastChange = currentTime
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+func (filesystem *Filesystem) Truncate(filePath string, size int64) error {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if !ino.IsRegular() {
+		return errors.New("not a regular file")
+	}
+	return filesystem.truncate(inodeNum, ino, size)
+}
@@ -0,0 +1,154 @@
+package filesystem
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+// hostStat is the part of a host file's metadata that os.FileInfo
+// doesn't have.
+type hostStat struct {
+	Uid, Gid     int
+	Nlink        uint64
+	Dev, Ino     uint64
+	Major, Minor int
+	AccessTime   time.Time
+}
+
+// Populate copies the contents of the host directory sourceDir into the
+// root directory, keeping modes, ownership, timestamps, extended
+// attributes and hard links.
+func (filesystem *Filesystem) Populate(sourceDir string) error {
+	links := map[[2]uint64]string{}
+	return filesystem.populate(sourceDir, "/", links)
+}
+
+func (filesystem *Filesystem) populate(sourceDir, targetDir string, links map[[2]uint64]string) error {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		sourcePath := filepath.Join(sourceDir, entry.Name())
+		targetPath := path.Join(targetDir, entry.Name())
+		info, err := os.Lstat(sourcePath)
+		if err != nil {
+			return err
+		}
+		stat := statHost(info)
+		if stat != nil && !info.IsDir() && stat.Nlink > 1 {
+			key := [2]uint64{stat.Dev, stat.Ino}
+			if linkTarget, ok := links[key]; ok {
+				if err = filesystem.Link(linkTarget, targetPath); err != nil {
+					return err
+				}
+				continue
+			}
+			links[key] = targetPath
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if err = filesystem.Mkdir(targetPath, int(mode.Perm())); err != nil {
+				return err
+			}
+			if err = filesystem.populate(sourcePath, targetPath, links); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err = filesystem.copyFile(sourcePath, targetPath); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(sourcePath)
+			if err != nil {
+				return err
+			}
+			if err = filesystem.Symlink(target, targetPath); err != nil {
+				return err
+			}
+		case mode&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) != 0 && stat != nil:
+			if err = filesystem.Mknod(targetPath, hostFileType(mode), stat.Major, stat.Minor); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		if mode&os.ModeSymlink == 0 {
+			if err = filesystem.Chmod(targetPath, hostPermissions(mode)); err != nil {
+				return err
+			}
+			if err = filesystem.copyXattrs(sourcePath, targetPath); err != nil {
+				return err
+			}
+		}
+		accessTime, modifyTime := info.ModTime(), info.ModTime()
+		if stat != nil {
+			if err = filesystem.Chown(targetPath, stat.Uid, stat.Gid); err != nil {
+				return err
+			}
+			accessTime = stat.AccessTime
+		}
+		if !filesystem.fixedTime.IsZero() {
+			if modifyTime.After(filesystem.fixedTime) {
+				modifyTime = filesystem.fixedTime
+			}
+			accessTime = modifyTime
+		}
+		if err = filesystem.Chtimes(targetPath, accessTime, modifyTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (filesystem *Filesystem) copyFile(sourcePath, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := filesystem.Create(targetPath, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(target, source)
+	return err
+}
+
+// hostFileType converts the type of a Go file mode for a special file to
+// its on-disk representation.
+func hostFileType(mode os.FileMode) int {
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		return inode.ModeCharDevice
+	case mode&os.ModeDevice != 0:
+		return inode.ModeBlockDevice
+	case mode&os.ModeNamedPipe != 0:
+		return inode.ModeFifo
+	}
+	return inode.ModeSocket
+}
+
+// hostPermissions converts the permission and special bits of a Go file
+// mode to their on-disk representation.
+func hostPermissions(mode os.FileMode) int {
+	permissions := int(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		permissions |= 0x800
+	}
+	if mode&os.ModeSetgid != 0 {
+		permissions |= 0x400
+	}
+	if mode&os.ModeSticky != 0 {
+		permissions |= 0x200
+	}
+	return permissions
+}
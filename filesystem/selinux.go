@@ -0,0 +1,55 @@
+package filesystem
+
+import (
+	"path"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/selinux"
+	"github.com/ErrorNoInternet/mkfs.ext2/xattr"
+)
+
+// Relabel sets the SELinux context of every file reachable from the root
+// directory to the one fileContexts gives its path. Files without a
+// matching spec keep their current context. A file with several hard links
+// is labeled for the first of its paths that has a matching spec, like
+// setfiles does.
+func (filesystem *Filesystem) Relabel(fileContexts *selinux.FileContexts) error {
+	return filesystem.relabel("/", inode.RootInode, fileContexts, map[int]bool{})
+}
+
+func (filesystem *Filesystem) relabel(filePath string, inodeNum int, fileContexts *selinux.FileContexts, labeled map[int]bool) error {
+	ino, err := filesystem.ReadInode(inodeNum)
+	if err != nil {
+		return err
+	}
+	if context, ok := fileContexts.Lookup(filePath, ino.Mode); ok && !labeled[inodeNum] {
+		attribute, err := xattr.Parse(selinux.XattrName, append([]byte(context), 0))
+		if err != nil {
+			return err
+		}
+		if _, err = filesystem.updateXattr(inodeNum, ino, attribute, false); err != nil {
+			return err
+		}
+		labeled[inodeNum] = true
+	}
+	if !ino.IsDirectory() {
+		return nil
+	}
+
+	children := []*dirEntry{}
+	_, err = filesystem.walkDirectory(ino, func(entry *dirEntry) bool {
+		if entry.Name != "." && entry.Name != ".." {
+			children = append(children, entry)
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = filesystem.relabel(path.Join(filePath, child.Name), child.InodeNum, fileContexts, labeled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/journal"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+	"github.com/google/uuid"
+)
+
+// SetFeature turns a feature of the filesystem on or off by its mke2fs
+// name. Only changes that can't leave existing data unreadable are
+// allowed: ext_attr and large_file can't be turned off again, and
+// sparse_super and filetype can't be changed at all.
+func (filesystem *Filesystem) SetFeature(name string, enable bool) error {
+	sb := filesystem.Superblock
+	if sb.RevLevel == 0 {
+		return errors.New("revision 0 filesystems don't support features")
+	}
+	switch name {
+	case "dir_index":
+		if !enable {
+			if err := filesystem.clearIndexFlags(); err != nil {
+				return err
+			}
+			return sb.SetFeaturesCompatible(sb.FeaturesCompatible &^ superblock.FeatureCompatDirIndex)
+		}
+		if sb.HashSeed == [16]byte{} {
+			sb.SetHashSeed([16]byte(uuid.New()))
+		}
+		if sb.Flags&(superblock.FlagSignedHash|superblock.FlagUnsignedHash) == 0 {
+			if err := sb.SetFlags(sb.Flags | superblock.FlagUnsignedHash); err != nil {
+				return err
+			}
+		}
+		return sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatDirIndex)
+	case "ext_attr":
+		if !enable {
+			return errors.New("ext_attr can't be turned off")
+		}
+		return sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatExtAttr)
+	case "large_file":
+		if !enable {
+			return errors.New("large_file can't be turned off")
+		}
+		return sb.SetFeaturesReadOnlyCompatible(sb.FeaturesReadOnlyCompatible | superblock.FeatureReadOnlyCompatLargeFile)
+	case "has_journal":
+		hasJournal := sb.FeaturesCompatible&superblock.FeatureCompatHasJournal != 0
+		if enable && !hasJournal {
+			return filesystem.createJournal(journal.DefaultSize(sb.NumBlocks))
+		}
+		if !enable && hasJournal {
+			return filesystem.removeJournal()
+		}
+		return nil
+	case "sparse_super", "filetype", "uninit_bg":
+		return errors.New(name + " can't be changed")
+	}
+	return errors.New("unknown feature: " + name)
+}
+
+// SetVolumeId changes the volume id, which the block group descriptor
+// checksums are based on.
+func (filesystem *Filesystem) SetVolumeId(volumeId [16]byte) {
+	filesystem.Superblock.SetVolumeId(volumeId)
+	for _, bgdtEntry := range filesystem.Bgdt.Entries {
+		bgdtEntry.UpdateChecksum()
+	}
+}
+
+// clearIndexFlags turns every hashed directory back into a linear one,
+// which it already is to anything that ignores the index blocks.
+func (filesystem *Filesystem) clearIndexFlags() error {
+	for inodeNum := 1; inodeNum <= filesystem.Superblock.NumInodes; inodeNum++ {
+		ino, err := filesystem.ReadInode(inodeNum)
+		if err != nil {
+			return err
+		}
+		if ino.NumLinks == 0 || !ino.IsDirectory() || !isIndexed(ino) {
+			continue
+		}
+		ino.Flags &^= inode.FlagIndex
+		if err = filesystem.WriteInode(inodeNum, ino); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package filesystem
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/acl"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+	"github.com/ErrorNoInternet/mkfs.ext2/xattr"
+)
+
+// inodeXattrSize returns the size of the attribute area at the end of a
+// large inode, or 0 if there is none.
+func (filesystem *Filesystem) inodeXattrSize(ino *inode.Inode) int {
+	size := filesystem.Superblock.InodeSize - 128 - ino.ExtraSize
+	if filesystem.Superblock.InodeSize <= 128 || size < 0 {
+		return 0
+	}
+	return size
+}
+
+func (filesystem *Filesystem) readXattrs(inodeNum int, ino *inode.Inode) ([]*xattr.Attribute, error) {
+	attributes := []*xattr.Attribute{}
+	if size := filesystem.inodeXattrSize(ino); size > 0 {
+		offset, err := filesystem.inodeOffset(inodeNum)
+		if err != nil {
+			return nil, err
+		}
+		data := filesystem.Device.Read(offset+int64(128+ino.ExtraSize), int64(size))
+		inInode, err := xattr.DecodeInode(data)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, inInode...)
+	}
+	if ino.FileAcl != 0 {
+		block, err := xattr.DecodeBlock(ReadBlock(filesystem.Device, filesystem.Superblock, ino.FileAcl, 0, 0))
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, block.Attributes...)
+	}
+	return attributes, nil
+}
+
+// writeXattrs replaces the attributes of an inode. As many as fit are kept
+// in the inode itself and the rest go to an attribute block, which is
+// shared with other inodes that have exactly the same ones.
+func (filesystem *Filesystem) writeXattrs(inodeNum int, ino *inode.Inode, attributes []*xattr.Attribute) error {
+	sb := filesystem.Superblock
+	if sb.RevLevel == 0 && len(attributes) > 0 {
+		return errors.New("revision 0 filesystems don't support extended attributes")
+	}
+	xattr.Sort(attributes)
+	size := filesystem.inodeXattrSize(ino)
+	inInode := []*xattr.Attribute{}
+	inBlock := []*xattr.Attribute{}
+	for _, attribute := range attributes {
+		if _, err := xattr.EncodeInode(append(inInode, attribute), size); err == nil {
+			inInode = append(inInode, attribute)
+		} else {
+			inBlock = append(inBlock, attribute)
+		}
+	}
+	inodeData, err := xattr.EncodeInode(inInode, size)
+	if err != nil {
+		return err
+	}
+	block := &xattr.Block{Refcount: 1, Attributes: inBlock}
+	blockData, err := block.Encode(sb.BlockSize)
+	if err != nil {
+		return err
+	}
+
+	if sb.FeaturesCompatible&superblock.FeatureCompatExtAttr == 0 {
+		sb.SetFeaturesCompatible(sb.FeaturesCompatible | superblock.FeatureCompatExtAttr)
+	}
+	if size > 0 {
+		offset, err := filesystem.inodeOffset(inodeNum)
+		if err != nil {
+			return err
+		}
+		filesystem.Device.Write(offset+int64(128+ino.ExtraSize), inodeData)
+	}
+	if err = filesystem.releaseXattrBlock(ino); err != nil {
+		return err
+	}
+	if len(inBlock) > 0 {
+		if err = filesystem.acquireXattrBlock(inodeNum, ino, block.Hash(), blockData); err != nil {
+			return err
+		}
+	}
+	ino.TimeLastChange = filesystem.now()
+	return filesystem.WriteInode(inodeNum, ino)
+}
+
+// acquireXattrBlock points an inode at an attribute block with the given
+// contents and a refcount of 1, reusing an identical block if there is one.
+func (filesystem *Filesystem) acquireXattrBlock(inodeNum int, ino *inode.Inode, hash uint32, data []byte) error {
+	sb := filesystem.Superblock
+	if filesystem.xattrBlocks == nil {
+		filesystem.xattrBlocks = map[uint32][]int{}
+	}
+	for _, bid := range filesystem.xattrBlocks[hash] {
+		block, err := xattr.DecodeBlock(ReadBlock(filesystem.Device, sb, bid, 0, 0))
+		if err != nil || block.Refcount >= xattr.MaxRefcount {
+			continue
+		}
+		refcount := block.Refcount
+		block.Refcount = 1
+		existing, err := block.Encode(sb.BlockSize)
+		if err != nil || !bytes.Equal(existing, data) {
+			continue
+		}
+		block.Refcount = refcount + 1
+		shared, err := block.Encode(sb.BlockSize)
+		if err != nil {
+			return err
+		}
+		WriteToBlock(filesystem.Device, sb, bid, 0, shared)
+		ino.FileAcl = bid
+		ino.NumSectors += filesystem.sectorsPerBlock()
+		return nil
+	}
+
+	bid, err := filesystem.AllocateBlock(filesystem.inodeGroup(inodeNum))
+	if err != nil {
+		return err
+	}
+	WriteToBlock(filesystem.Device, sb, bid, 0, data)
+	filesystem.xattrBlocks[hash] = append(filesystem.xattrBlocks[hash], bid)
+	ino.FileAcl = bid
+	ino.NumSectors += filesystem.sectorsPerBlock()
+	return nil
+}
+
+// releaseXattrBlock drops the reference an inode holds on its attribute
+// block, freeing the block once no inode uses it anymore.
+func (filesystem *Filesystem) releaseXattrBlock(ino *inode.Inode) error {
+	if ino.FileAcl == 0 {
+		return nil
+	}
+	sb := filesystem.Superblock
+	bid := ino.FileAcl
+	ino.FileAcl = 0
+	ino.NumSectors -= filesystem.sectorsPerBlock()
+	block, err := xattr.DecodeBlock(ReadBlock(filesystem.Device, sb, bid, 0, 0))
+	if err != nil {
+		return err
+	}
+	if block.Refcount > 1 {
+		block.Refcount -= 1
+		data, err := block.Encode(sb.BlockSize)
+		if err != nil {
+			return err
+		}
+		WriteToBlock(filesystem.Device, sb, bid, 0, data)
+		return nil
+	}
+
+	hash := block.Hash()
+	for i, cached := range filesystem.xattrBlocks[hash] {
+		if cached == bid {
+			filesystem.xattrBlocks[hash] = append(filesystem.xattrBlocks[hash][:i], filesystem.xattrBlocks[hash][i+1:]...)
+			break
+		}
+	}
+	return filesystem.FreeBlock(bid)
+}
+
+// updateXattr creates, replaces or (when remove is set) removes the
+// attribute of an inode with the namespace and name of attribute, and
+// reports whether it existed before.
+func (filesystem *Filesystem) updateXattr(inodeNum int, ino *inode.Inode, attribute *xattr.Attribute, remove bool) (bool, error) {
+	attributes, err := filesystem.readXattrs(inodeNum, ino)
+	if err != nil {
+		return false, err
+	}
+	i := xattr.Find(attributes, attribute.Index, attribute.Name)
+	switch {
+	case remove && i == -1:
+		return false, nil
+	case remove:
+		attributes = append(attributes[:i], attributes[i+1:]...)
+	case i == -1:
+		attributes = append(attributes, attribute)
+	default:
+		attributes[i] = attribute
+	}
+	return i != -1, filesystem.writeXattrs(inodeNum, ino, attributes)
+}
+
+func (filesystem *Filesystem) getXattr(inodeNum int, ino *inode.Inode, index int, name string) (*xattr.Attribute, error) {
+	attributes, err := filesystem.readXattrs(inodeNum, ino)
+	if err != nil {
+		return nil, err
+	}
+	i := xattr.Find(attributes, index, name)
+	if i == -1 {
+		return nil, nil
+	}
+	return attributes[i], nil
+}
+
+// Getxattr returns the value of the extended attribute name of a file.
+// ACLs are returned in the format used by the xattr system calls.
+func (filesystem *Filesystem) Getxattr(filePath, name string) ([]byte, error) {
+	attribute, err := xattr.Parse(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := filesystem.getXattr(inodeNum, ino, attribute.Index, attribute.Name)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errors.New("no such attribute")
+	}
+	if isAclIndex(stored.Index) {
+		entries, err := acl.Decode(stored.Value)
+		if err != nil {
+			return nil, err
+		}
+		return acl.EncodeXattr(entries), nil
+	}
+	return stored.Value, nil
+}
+
+// Setxattr creates or replaces the extended attribute name of a file.
+// Only the user, trusted and security namespaces and ACLs (in the format
+// used by the xattr system calls) are supported.
+func (filesystem *Filesystem) Setxattr(filePath, name string, value []byte) error {
+	attribute, err := xattr.Parse(name, value)
+	if err != nil {
+		return err
+	}
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	if isAclIndex(attribute.Index) {
+		entries, err := acl.DecodeXattr(value)
+		if err != nil {
+			return err
+		}
+		return filesystem.setAcl(inodeNum, ino, attribute.Index == xattr.IndexPosixAclDefault, entries)
+	}
+	_, err = filesystem.updateXattr(inodeNum, ino, attribute, false)
+	return err
+}
+
+func (filesystem *Filesystem) Listxattr(filePath string) ([]string, error) {
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := filesystem.readXattrs(inodeNum, ino)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, attribute := range attributes {
+		names = append(names, attribute.FullName())
+	}
+	return names, nil
+}
+
+func (filesystem *Filesystem) Removexattr(filePath, name string) error {
+	attribute, err := xattr.Parse(name, nil)
+	if err != nil {
+		return err
+	}
+	inodeNum, ino, err := filesystem.lookup(filePath)
+	if err != nil {
+		return err
+	}
+	found, err := filesystem.updateXattr(inodeNum, ino, attribute, true)
+	if err == nil && !found {
+		return errors.New("no such attribute")
+	}
+	return err
+}
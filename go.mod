@@ -4,4 +4,6 @@ go 1.18
 
 require github.com/google/uuid v1.3.0
 
-require github.com/roman-kachanovsky/go-binary-pack v0.0.0-20170214094030-e260e0dc6732 // indirect
+require github.com/roman-kachanovsky/go-binary-pack v0.0.0-20170214094030-e260e0dc6732
+
+require golang.org/x/sys v0.15.0
@@ -0,0 +1,189 @@
+package htree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	HashLegacy          = 0
+	HashHalfMD4         = 1
+	HashTea             = 2
+	HashLegacyUnsigned  = 3
+	HashHalfMD4Unsigned = 4
+	HashTeaUnsigned     = 5
+)
+
+func HashVersionByName(name string) (int, error) {
+	switch name {
+	case "legacy":
+		return HashLegacy, nil
+	case "half_md4":
+		return HashHalfMD4, nil
+	case "tea":
+		return HashTea, nil
+	}
+	return 0, errors.New("unknown hash algorithm: " + name)
+}
+
+func teaTransform(buf *[4]uint32, in []uint32) {
+	var sum uint32
+	b0, b1 := buf[0], buf[1]
+	a, b, c, d := in[0], in[1], in[2], in[3]
+	for n := 0; n < 16; n++ {
+		sum += 0x9E3779B9
+		b0 += ((b1 << 4) + a) ^ (b1 + sum) ^ ((b1 >> 5) + b)
+		b1 += ((b0 << 4) + c) ^ (b0 + sum) ^ ((b0 >> 5) + d)
+	}
+	buf[0] += b0
+	buf[1] += b1
+}
+
+func rotateLeft(x uint32, s uint) uint32 {
+	return x<<s | x>>(32-s)
+}
+
+func halfMD4Transform(buf *[4]uint32, in []uint32) {
+	f := func(x, y, z uint32) uint32 { return z ^ (x & (y ^ z)) }
+	g := func(x, y, z uint32) uint32 { return (x & y) + ((x ^ y) & z) }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	const k2 = 013240474631
+	const k3 = 015666365641
+
+	a, b, c, d := buf[0], buf[1], buf[2], buf[3]
+
+	a = rotateLeft(a+f(b, c, d)+in[0], 3)
+	d = rotateLeft(d+f(a, b, c)+in[1], 7)
+	c = rotateLeft(c+f(d, a, b)+in[2], 11)
+	b = rotateLeft(b+f(c, d, a)+in[3], 19)
+	a = rotateLeft(a+f(b, c, d)+in[4], 3)
+	d = rotateLeft(d+f(a, b, c)+in[5], 7)
+	c = rotateLeft(c+f(d, a, b)+in[6], 11)
+	b = rotateLeft(b+f(c, d, a)+in[7], 19)
+
+	a = rotateLeft(a+g(b, c, d)+in[1]+k2, 3)
+	d = rotateLeft(d+g(a, b, c)+in[3]+k2, 5)
+	c = rotateLeft(c+g(d, a, b)+in[5]+k2, 9)
+	b = rotateLeft(b+g(c, d, a)+in[7]+k2, 13)
+	a = rotateLeft(a+g(b, c, d)+in[0]+k2, 3)
+	d = rotateLeft(d+g(a, b, c)+in[2]+k2, 5)
+	c = rotateLeft(c+g(d, a, b)+in[4]+k2, 9)
+	b = rotateLeft(b+g(c, d, a)+in[6]+k2, 13)
+
+	a = rotateLeft(a+h(b, c, d)+in[3]+k3, 3)
+	d = rotateLeft(d+h(a, b, c)+in[7]+k3, 9)
+	c = rotateLeft(c+h(d, a, b)+in[2]+k3, 11)
+	b = rotateLeft(b+h(c, d, a)+in[6]+k3, 15)
+	a = rotateLeft(a+h(b, c, d)+in[1]+k3, 3)
+	d = rotateLeft(d+h(a, b, c)+in[5]+k3, 9)
+	c = rotateLeft(c+h(d, a, b)+in[0]+k3, 11)
+	b = rotateLeft(b+h(c, d, a)+in[4]+k3, 15)
+
+	buf[0] += a
+	buf[1] += b
+	buf[2] += c
+	buf[3] += d
+}
+
+func character(name []byte, i int, unsigned bool) uint32 {
+	if unsigned {
+		return uint32(name[i])
+	}
+	return uint32(int32(int8(name[i])))
+}
+
+func legacyHash(name []byte, unsigned bool) uint32 {
+	var hash uint32
+	hash0, hash1 := uint32(0x12a3fe2d), uint32(0x37abe8f9)
+	for i := range name {
+		hash = hash1 + (hash0 ^ (character(name, i, unsigned) * 7152373))
+		if hash&0x80000000 != 0 {
+			hash -= 0x7fffffff
+		}
+		hash1 = hash0
+		hash0 = hash
+	}
+	return hash0 << 1
+}
+
+// stringToHashBuffer packs up to num*4 bytes of name into num words,
+// padding with a value derived from the name length.
+func stringToHashBuffer(name []byte, num int, unsigned bool) []uint32 {
+	length := len(name)
+	pad := uint32(length) | uint32(length)<<8
+	pad |= pad << 16
+
+	buf := []uint32{}
+	val := pad
+	if length > num*4 {
+		length = num * 4
+	}
+	for i := 0; i < length; i++ {
+		val = character(name, i, unsigned) + (val << 8)
+		if i%4 == 3 {
+			buf = append(buf, val)
+			val = pad
+		}
+	}
+	if len(buf) < num {
+		buf = append(buf, val)
+	}
+	for len(buf) < num {
+		buf = append(buf, pad)
+	}
+	return buf
+}
+
+// Hash returns the major and minor directory index hashes of name. An
+// all-zero seed selects the default seed.
+func Hash(name string, version int, seed [16]byte) (uint32, uint32, error) {
+	buf := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	if seed != [16]byte{} {
+		for i := range buf {
+			buf[i] = binary.LittleEndian.Uint32(seed[i*4:])
+		}
+	}
+
+	var hash, minorHash uint32
+	data := []byte(name)
+	unsigned := version >= HashLegacyUnsigned
+	switch version {
+	case HashLegacy, HashLegacyUnsigned:
+		hash = legacyHash(data, unsigned)
+	case HashHalfMD4, HashHalfMD4Unsigned:
+		for p := data; len(p) > 0; {
+			halfMD4Transform(&buf, stringToHashBuffer(p, 8, unsigned))
+			if len(p) <= 32 {
+				break
+			}
+			p = p[32:]
+		}
+		hash = buf[1]
+		minorHash = buf[2]
+	case HashTea, HashTeaUnsigned:
+		for p := data; len(p) > 0; {
+			teaTransform(&buf, stringToHashBuffer(p, 4, unsigned))
+			if len(p) <= 16 {
+				break
+			}
+			p = p[16:]
+		}
+		hash = buf[0]
+		minorHash = buf[1]
+	default:
+		return 0, 0, errors.New("unsupported hash version")
+	}
+
+	return finishHash(hash), minorHash, nil
+}
+
+// finishHash clears the lowest bit of a major hash, which marks hash
+// collisions in index entries, and moves the hash reserved for the end of
+// the directory to the one below it.
+func finishHash(hash uint32) uint32 {
+	hash &^= 1
+	if hash == 0x7fffffff<<1 {
+		hash = (0x7fffffff - 1) << 1
+	}
+	return hash
+}
@@ -0,0 +1,75 @@
+package htree
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// The expected hashes were computed by e2fsprogs' debugfs dx_hash.
+var hashTests = []struct {
+	name      string
+	version   int
+	seed      string
+	hash      uint32
+	minorHash uint32
+}{
+	{"lost+found", HashLegacy, "", 0x5e2aba24, 0},
+	{"lost+found", HashHalfMD4, "", 0x591de422, 0x6ffc56e0},
+	{"lost+found", HashTea, "", 0x2dbf9e80, 0xbfebee4f},
+	{"lost+found", HashHalfMD4Unsigned, "", 0x591de422, 0x6ffc56e0},
+	{"lost+found", HashHalfMD4, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x1e3eb6ea, 0xeb2e2719},
+	{"lost+found", HashTea, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x87130328, 0x7fa480ea},
+
+	{"café", HashLegacy, "", 0x96ca5a2c, 0},
+	{"café", HashHalfMD4, "", 0xfb9c5e5c, 0x0573e8b8},
+	{"café", HashTea, "", 0x105842ea, 0xfb9165ca},
+	{"café", HashLegacyUnsigned, "", 0x6dde4230, 0},
+	{"café", HashHalfMD4Unsigned, "", 0x9d72aed6, 0xf6138c6a},
+	{"café", HashTeaUnsigned, "", 0x6621f032, 0xf86699c6},
+	{"café", HashHalfMD4, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x91037acc, 0x818816b5},
+	{"café", HashTea, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x118a7bd6, 0x4c643ca3},
+	{"café", HashHalfMD4Unsigned, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0xa18b7168, 0xe80a0f47},
+	{"café", HashTeaUnsigned, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x8b3781b0, 0x8bfdd13a},
+
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashLegacy, "", 0xe24d4d9a, 0},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashHalfMD4, "", 0x1a9dc160, 0xde986e6d},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashTea, "", 0xe6a914c6, 0x0d720f4f},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashLegacyUnsigned, "", 0x41d58e46, 0},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashHalfMD4Unsigned, "", 0x12339e48, 0x87eab2ab},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashTeaUnsigned, "", 0xd4f711be, 0x6da627f1},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashHalfMD4Unsigned, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0xfb9035ba, 0x4888ec51},
+	{"a-much-longer-file-name-with-ümläuts-and-more-than-32-bytes.txt", HashTeaUnsigned, "6ba70f56-0b1b-4c9e-9c43-2d7b4a1c5e11", 0x28c85a34, 0xde0b42cb},
+}
+
+func TestHash(t *testing.T) {
+	for _, test := range hashTests {
+		seed := [16]byte{}
+		if test.seed != "" {
+			seed = uuid.MustParse(test.seed)
+		}
+		hash, minorHash, err := Hash(test.name, test.version, seed)
+		if err != nil {
+			t.Errorf("Hash(%q, %d, %q): %v", test.name, test.version, test.seed, err)
+			continue
+		}
+		if hash != test.hash || minorHash != test.minorHash {
+			t.Errorf("Hash(%q, %d, %q) = %#x, %#x, want %#x, %#x", test.name, test.version, test.seed, hash, minorHash, test.hash, test.minorHash)
+		}
+	}
+}
+
+func TestFinishHash(t *testing.T) {
+	tests := map[uint32]uint32{
+		0x00000000: 0x00000000,
+		0x12345679: 0x12345678,
+		0xfffffffc: 0xfffffffc,
+		0xfffffffe: 0xfffffffc,
+		0xffffffff: 0xfffffffc,
+	}
+	for hash, want := range tests {
+		if got := finishHash(hash); got != want {
+			t.Errorf("finishHash(%#x) = %#x, want %#x", hash, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package htree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// MaxLevels is the number of index levels below the root that ext2 (and
+// ext3) directory indexes can have.
+const MaxLevels = 1
+
+// Entry maps hashes starting at Hash to the logical directory block Block.
+// The hash of the first entry of a node is implied to be 0. A set low bit
+// means the previous block holds names with the same hash.
+type Entry struct {
+	Hash  uint32
+	Block int
+}
+
+// Node is an index block of a hashed directory. The root lives in the
+// first directory block behind the "." and ".." entries, while interior
+// nodes are hidden behind an empty entry spanning their whole block.
+type Node struct {
+	Root        bool
+	HashVersion int
+	Levels      int
+	Limit       int
+	Entries     []Entry
+}
+
+func entriesOffset(root bool) int {
+	if root {
+		return 32
+	}
+	return 8
+}
+
+// Limit returns how many entries fit in an index block.
+func Limit(blockSize int, root bool) int {
+	return (blockSize - entriesOffset(root)) / 8
+}
+
+func NewNode(blockSize int, root bool) *Node {
+	return &Node{
+		Root:  root,
+		Limit: Limit(blockSize, root),
+	}
+}
+
+func Decode(data []byte, root bool) (*Node, error) {
+	le := binary.LittleEndian
+	node := &Node{Root: root}
+	offset := entriesOffset(root)
+	if root {
+		if le.Uint32(data[24:]) != 0 || data[29] != 8 {
+			return nil, errors.New("invalid directory index root")
+		}
+		node.HashVersion = int(data[28])
+		node.Levels = int(data[30])
+		if node.Levels > MaxLevels {
+			return nil, errors.New("unsupported directory index depth")
+		}
+	}
+	node.Limit = int(le.Uint16(data[offset:]))
+	count := int(le.Uint16(data[offset+2:]))
+	if node.Limit != Limit(len(data), root) || count == 0 || count > node.Limit {
+		return nil, errors.New("invalid directory index count")
+	}
+	node.Entries = make([]Entry, count)
+	for i := range node.Entries {
+		node.Entries[i].Block = int(le.Uint32(data[offset+i*8+4:]))
+		if i > 0 {
+			node.Entries[i].Hash = le.Uint32(data[offset+i*8:])
+		}
+	}
+	return node, nil
+}
+
+// Encode writes the node into its directory block. The "." and ".."
+// entries of a root, and the empty entry of an interior node, are left to
+// the caller.
+func (node *Node) Encode(data []byte) {
+	le := binary.LittleEndian
+	offset := entriesOffset(node.Root)
+	if node.Root {
+		le.PutUint32(data[24:], 0)
+		data[28] = uint8(node.HashVersion)
+		data[29] = 8
+		data[30] = uint8(node.Levels)
+		data[31] = 0
+	} else {
+		le.PutUint32(data[0:], 0)
+		le.PutUint16(data[4:], uint16(len(data)))
+		le.PutUint16(data[6:], 0)
+	}
+	for i := offset; i < len(data); i++ {
+		data[i] = 0
+	}
+	le.PutUint16(data[offset:], uint16(node.Limit))
+	le.PutUint16(data[offset+2:], uint16(len(node.Entries)))
+	for i, entry := range node.Entries {
+		if i > 0 {
+			le.PutUint32(data[offset+i*8:], entry.Hash)
+		}
+		le.PutUint32(data[offset+i*8+4:], uint32(entry.Block))
+	}
+}
+
+// Find returns the index of the entry covering hash.
+func (node *Node) Find(hash uint32) int {
+	low, high := 1, len(node.Entries)-1
+	for low <= high {
+		middle := (low + high) / 2
+		if node.Entries[middle].Hash > hash {
+			high = middle - 1
+		} else {
+			low = middle + 1
+		}
+	}
+	return low - 1
+}
+
+// Insert adds an entry right after the entry at index.
+func (node *Node) Insert(index int, entry Entry) error {
+	if len(node.Entries) >= node.Limit {
+		return errors.New("directory index node is full")
+	}
+	node.Entries = append(node.Entries, Entry{})
+	copy(node.Entries[index+2:], node.Entries[index+1:])
+	node.Entries[index+1] = entry
+	return nil
+}
@@ -0,0 +1,244 @@
+package inode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	binary_pack "github.com/roman-kachanovsky/go-binary-pack/binary-pack"
+)
+
+const (
+	BadBlocksInode = 1
+	RootInode      = 2
+
+	// FirstInode is the first inode that isn't reserved. The reserved
+	// ones are all in the first block group.
+	FirstInode = 11
+
+	NumDirectBlocks = 12
+	IndirectBlock   = 12
+	DoubleIndirect  = 13
+	TripleIndirect  = 14
+
+	ModeTypeMask    = 0xF000
+	ModeSocket      = 0xC000
+	ModeSymlink     = 0xA000
+	ModeRegular     = 0x8000
+	ModeBlockDevice = 0x6000
+	ModeDirectory   = 0x4000
+	ModeCharDevice  = 0x2000
+	ModeFifo        = 0x1000
+	ModePermissions = 0x0FFF
+
+	FlagIndex = 0x1000
+)
+
+type Inode struct {
+	Mode             int
+	Uid              int
+	Gid              int
+	Size             int64
+	TimeLastAccess   time.Time
+	TimeLastChange   time.Time
+	TimeLastModify   time.Time
+	TimeCreation     time.Time
+	TimeDeletion     int64
+	NumLinks         int
+	NumSectors       int
+	Flags            int
+	Blocks           [15]int
+	Generation       int
+	FileAcl          int
+	FragmentAddress  int
+	OperatingSystem1 int
+	// ExtraSize is the number of bytes past the first 128 that are used
+	// by the fields of large inodes (i_extra_isize).
+	ExtraSize int
+}
+
+// ExtraSize is the size of the large inode fields written to inodes of
+// more than 128 bytes, up to and including i_projid.
+const ExtraSize = 32
+
+// encodeTime splits a timestamp into its 32-bit seconds and the extra
+// field holding two more epoch bits and the nanoseconds.
+func encodeTime(timestamp time.Time) (int, int) {
+	if timestamp.IsZero() {
+		return 0, 0
+	}
+	seconds := timestamp.Unix()
+	epoch := ((seconds - int64(int32(seconds))) >> 32) & 3
+	return int(uint32(seconds)), int(epoch) | timestamp.Nanosecond()<<2
+}
+
+func decodeTime(seconds, extra uint32, hasExtra bool) time.Time {
+	fullSeconds := int64(int32(seconds))
+	nanoseconds := 0
+	if hasExtra {
+		fullSeconds += int64(extra&3) << 32
+		nanoseconds = int(extra >> 2)
+	}
+	return time.Unix(fullSeconds, int64(nanoseconds))
+}
+
+func (inode *Inode) IsDirectory() bool {
+	return inode.Mode&ModeTypeMask == ModeDirectory
+}
+
+func (inode *Inode) IsRegular() bool {
+	return inode.Mode&ModeTypeMask == ModeRegular
+}
+
+func (inode *Inode) IsSymlink() bool {
+	return inode.Mode&ModeTypeMask == ModeSymlink
+}
+
+// IsFastSymlink reports whether the symlink target is stored inline in
+// the block pointers instead of in a data block. This only depends on the
+// length of the target, as an extended attribute block also counts
+// towards the sectors of the inode.
+func (inode *Inode) IsFastSymlink() bool {
+	return inode.IsSymlink() && inode.Size > 0 && inode.Size < 60
+}
+
+// HasDataBlocks reports whether the block pointers reference data blocks,
+// as opposed to device numbers or an inline symlink target.
+func (inode *Inode) HasDataBlocks() bool {
+	switch inode.Mode & ModeTypeMask {
+	case ModeCharDevice, ModeBlockDevice, ModeFifo, ModeSocket:
+		return false
+	}
+	return !inode.IsFastSymlink()
+}
+
+// SetDevice stores the device number of a character or block device in
+// the first block pointer, or in the second one using the larger format
+// if it doesn't fit in 16 bits.
+func (inode *Inode) SetDevice(major, minor int) {
+	inode.Blocks = [15]int{}
+	if major < 256 && minor < 256 {
+		inode.Blocks[0] = major<<8 | minor
+	} else {
+		inode.Blocks[1] = minor&0xFF | major<<8 | (minor&^0xFF)<<12
+	}
+}
+
+func (inode *Inode) Device() (int, int) {
+	if inode.Blocks[0] != 0 {
+		return inode.Blocks[0] >> 8 & 0xFF, inode.Blocks[0] & 0xFF
+	}
+	device := inode.Blocks[1]
+	return device >> 8 & 0xFFF, device&0xFF | device>>12&^0xFF
+}
+
+// Encode returns the first 128 bytes of the inode followed by ExtraSize
+// bytes of large inode fields.
+func (inode *Inode) Encode() ([]byte, error) {
+	accessTime, accessExtra := encodeTime(inode.TimeLastAccess)
+	changeTime, changeExtra := encodeTime(inode.TimeLastChange)
+	modifyTime, modifyExtra := encodeTime(inode.TimeLastModify)
+	creationTime, creationExtra := encodeTime(inode.TimeCreation)
+
+	format := []string{"H", "H", "I", "I", "I", "I", "I", "H", "H", "I", "I", "I"}
+	values := []interface{}{
+		inode.Mode & 0xFFFF,
+		inode.Uid & 0xFFFF,
+		int(inode.Size & 0xFFFFFFFF),
+		accessTime,
+		changeTime,
+		modifyTime,
+		int(inode.TimeDeletion),
+		inode.Gid & 0xFFFF,
+		inode.NumLinks,
+		inode.NumSectors,
+		inode.Flags,
+		inode.OperatingSystem1,
+	}
+	for _, block := range inode.Blocks {
+		format = append(format, "I")
+		values = append(values, block)
+	}
+	format = append(format, "I", "I", "I", "I", "4s", "H", "H", "4s")
+	values = append(values,
+		inode.Generation,
+		inode.FileAcl,
+		int(inode.Size>>32),
+		inode.FragmentAddress,
+		"",
+		inode.Uid>>16,
+		inode.Gid>>16,
+		"",
+	)
+	if inode.ExtraSize > 0 {
+		format = append(format, "H", "H", "I", "I", "I", "I", "I", "I", "I")
+		values = append(values,
+			inode.ExtraSize,
+			0,
+			changeExtra,
+			modifyExtra,
+			accessExtra,
+			creationTime,
+			creationExtra,
+			0,
+			0,
+		)
+		if inode.ExtraSize > ExtraSize {
+			format = append(format, fmt.Sprintf("%vs", inode.ExtraSize-ExtraSize))
+			values = append(values, "")
+		}
+	}
+	bp := new(binary_pack.BinaryPack)
+	data, err := bp.Pack(format, values)
+	if err != nil {
+		return nil, errors.New("unable to pack bytes: " + err.Error())
+	}
+	if inode.ExtraSize > 0 && inode.ExtraSize < ExtraSize {
+		data = data[:128+inode.ExtraSize]
+	}
+	return data, nil
+}
+
+func Decode(data []byte) (*Inode, error) {
+	if len(data) < 128 {
+		return nil, errors.New("inode data is too short")
+	}
+	le := binary.LittleEndian
+	inode := &Inode{
+		Mode:             int(le.Uint16(data[0:])),
+		Uid:              int(le.Uint16(data[2:])) | int(le.Uint16(data[120:]))<<16,
+		Size:             int64(le.Uint32(data[4:])),
+		TimeDeletion:     int64(le.Uint32(data[20:])),
+		Gid:              int(le.Uint16(data[24:])) | int(le.Uint16(data[122:]))<<16,
+		NumLinks:         int(le.Uint16(data[26:])),
+		NumSectors:       int(le.Uint32(data[28:])),
+		Flags:            int(le.Uint32(data[32:])),
+		OperatingSystem1: int(le.Uint32(data[36:])),
+		Generation:       int(le.Uint32(data[100:])),
+		FileAcl:          int(le.Uint32(data[104:])),
+		FragmentAddress:  int(le.Uint32(data[112:])),
+	}
+	for i := range inode.Blocks {
+		inode.Blocks[i] = int(le.Uint32(data[40+i*4:]))
+	}
+
+	extra := make([]byte, 24)
+	if len(data) > 130 {
+		inode.ExtraSize = int(le.Uint16(data[128:]))
+		if inode.ExtraSize > len(data)-128 {
+			return nil, errors.New("invalid inode extra size")
+		}
+		copy(extra, data[128:128+inode.ExtraSize])
+	}
+	inode.TimeLastChange = decodeTime(le.Uint32(data[12:]), le.Uint32(extra[4:]), inode.ExtraSize >= 8)
+	inode.TimeLastModify = decodeTime(le.Uint32(data[16:]), le.Uint32(extra[8:]), inode.ExtraSize >= 12)
+	inode.TimeLastAccess = decodeTime(le.Uint32(data[8:]), le.Uint32(extra[12:]), inode.ExtraSize >= 16)
+	if inode.ExtraSize >= 20 {
+		inode.TimeCreation = decodeTime(le.Uint32(extra[16:]), le.Uint32(extra[20:]), inode.ExtraSize >= 24)
+	}
+	if inode.IsRegular() {
+		inode.Size |= int64(le.Uint32(data[108:])) << 32
+	}
+	return inode, nil
+}
@@ -0,0 +1,244 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/device"
+)
+
+const (
+	FeatureIncompatRevoke      = 0x0001
+	FeatureIncompat64Bit       = 0x0002
+	FeatureIncompatAsyncCommit = 0x0004
+	FeatureIncompatChecksumV2  = 0x0008
+	FeatureIncompatChecksumV3  = 0x0010
+
+	tagFlagEscape   = 0x1
+	tagFlagSameUuid = 0x2
+	tagFlagLastTag  = 0x8
+)
+
+// Journal is an internal journal, described by the filesystem blocks
+// backing each of its logical blocks.
+type Journal struct {
+	Device     *device.Device
+	Superblock *Superblock
+	Blocks     []int
+}
+
+// tag is a filesystem block logged in a descriptor block.
+type tag struct {
+	Bid     int
+	Escaped bool
+}
+
+func Load(dev *device.Device, blockSize int, blocks []int) (*Journal, error) {
+	if len(blocks) == 0 {
+		return nil, errors.New("journal has no blocks")
+	}
+	sb, err := DecodeSuperblock(dev.Read(int64(blocks[0]*blockSize), 1024))
+	if err != nil {
+		return nil, err
+	}
+	if sb.BlockSize != blockSize || sb.MaxLen > len(blocks) || sb.First < 1 || sb.First >= sb.MaxLen {
+		return nil, errors.New("journal superblock doesn't match the journal inode")
+	}
+	if sb.FeaturesIncompatible&^(FeatureIncompatRevoke|FeatureIncompat64Bit|FeatureIncompatAsyncCommit|FeatureIncompatChecksumV2|FeatureIncompatChecksumV3) != 0 {
+		return nil, errors.New("journal has unsupported incompatible features")
+	}
+	return &Journal{
+		Device:     dev,
+		Superblock: sb,
+		Blocks:     blocks,
+	}, nil
+}
+
+func (journal *Journal) readBlock(logical int) []byte {
+	blockSize := journal.Superblock.BlockSize
+	return journal.Device.Read(int64(journal.Blocks[logical]*blockSize), int64(blockSize))
+}
+
+// next returns the logical block following logical, wrapping around the
+// end of the log.
+func (journal *Journal) next(logical int) int {
+	logical += 1
+	if logical >= journal.Superblock.MaxLen {
+		logical = journal.Superblock.First
+	}
+	return logical
+}
+
+func (journal *Journal) tagBytes() int {
+	incompat := journal.Superblock.FeaturesIncompatible
+	if incompat&FeatureIncompatChecksumV3 != 0 {
+		return 16
+	}
+	size := 12
+	if incompat&FeatureIncompatChecksumV2 != 0 {
+		size += 2
+	}
+	if incompat&FeatureIncompat64Bit != 0 {
+		return size
+	}
+	return size - 4
+}
+
+func (journal *Journal) parseTags(data []byte) []tag {
+	be := binary.BigEndian
+	incompat := journal.Superblock.FeaturesIncompatible
+	end := len(data)
+	if incompat&(FeatureIncompatChecksumV2|FeatureIncompatChecksumV3) != 0 {
+		end -= 4
+	}
+	tags := []tag{}
+	size := journal.tagBytes()
+	for offset := 12; offset+size <= end; {
+		bid := int(be.Uint32(data[offset:]))
+		var flags int
+		if incompat&FeatureIncompatChecksumV3 != 0 {
+			flags = int(be.Uint32(data[offset+4:]))
+			if incompat&FeatureIncompat64Bit != 0 {
+				bid |= int(be.Uint32(data[offset+8:])) << 32
+			}
+		} else {
+			flags = int(be.Uint16(data[offset+6:]))
+			if incompat&FeatureIncompat64Bit != 0 {
+				bid |= int(be.Uint32(data[offset+8:])) << 32
+			}
+		}
+		tags = append(tags, tag{Bid: bid, Escaped: flags&tagFlagEscape != 0})
+		offset += size
+		if flags&tagFlagSameUuid == 0 {
+			offset += 16
+		}
+		if flags&tagFlagLastTag != 0 {
+			break
+		}
+	}
+	return tags
+}
+
+func (journal *Journal) parseRevoke(data []byte) []int {
+	be := binary.BigEndian
+	count := int(be.Uint32(data[12:]))
+	if count > len(data) {
+		count = len(data)
+	}
+	size := 4
+	if journal.Superblock.FeaturesIncompatible&FeatureIncompat64Bit != 0 {
+		size = 8
+	}
+	revoked := []int{}
+	for offset := 16; offset+size <= count; offset += size {
+		if size == 8 {
+			revoked = append(revoked, int(be.Uint64(data[offset:])))
+		} else {
+			revoked = append(revoked, int(be.Uint32(data[offset:])))
+		}
+	}
+	return revoked
+}
+
+// walk calls fn with every block of the log belonging to a committed
+// transaction, along with its sequence number, and returns the sequence
+// number following the last committed transaction.
+func (journal *Journal) walk(fn func(sequence, logical int, data []byte) error) (int, error) {
+	be := binary.BigEndian
+	sequence := journal.Superblock.Sequence
+	logical := journal.Superblock.Start
+	committed := []func() error{}
+	for steps := 0; steps < journal.Superblock.MaxLen; steps++ {
+		data := journal.readBlock(logical)
+		if be.Uint32(data[0:]) != Magic || int(be.Uint32(data[8:])) != sequence {
+			break
+		}
+		blockLogical := logical
+		blockSequence := sequence
+		committed = append(committed, func() error {
+			return fn(blockSequence, blockLogical, data)
+		})
+
+		switch be.Uint32(data[4:]) {
+		case BlockTypeDescriptor:
+			for range journal.parseTags(data) {
+				logical = journal.next(logical)
+				steps += 1
+			}
+		case BlockTypeCommit:
+			for _, call := range committed {
+				if err := call(); err != nil {
+					return 0, err
+				}
+			}
+			committed = committed[:0]
+			sequence += 1
+		case BlockTypeRevoke:
+		default:
+			return 0, errors.New("invalid journal block type")
+		}
+		logical = journal.next(logical)
+	}
+	return sequence, nil
+}
+
+// Replay writes the filesystem blocks logged by every committed
+// transaction back to the device, skipping blocks that a later revoke
+// record cancelled, and marks the journal as empty. It returns the number
+// of transactions replayed.
+func (journal *Journal) Replay() (int, error) {
+	sb := journal.Superblock
+	if sb.Start == 0 {
+		return 0, nil
+	}
+
+	revoked := map[int]int{}
+	_, err := journal.walk(func(sequence, logical int, data []byte) error {
+		if binary.BigEndian.Uint32(data[4:]) == BlockTypeRevoke {
+			for _, bid := range journal.parseRevoke(data) {
+				if revoked[bid] < sequence {
+					revoked[bid] = sequence
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	endSequence, err := journal.walk(func(sequence, logical int, data []byte) error {
+		if binary.BigEndian.Uint32(data[4:]) != BlockTypeDescriptor {
+			return nil
+		}
+		for _, tag := range journal.parseTags(data) {
+			logical = journal.next(logical)
+			if revokeSequence, ok := revoked[tag.Bid]; ok && revokeSequence >= sequence {
+				continue
+			}
+			block := journal.readBlock(logical)
+			if tag.Escaped {
+				binary.BigEndian.PutUint32(block[0:], Magic)
+			}
+			journal.Device.Write(int64(tag.Bid*sb.BlockSize), block)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := endSequence - sb.Sequence
+	sb.Sequence = endSequence
+	sb.Start = 0
+	sbData := journal.Device.Read(int64(journal.Blocks[0]*sb.BlockSize), 1024)
+	binary.BigEndian.PutUint32(sbData[24:], uint32(sb.Sequence))
+	binary.BigEndian.PutUint32(sbData[28:], uint32(sb.Start))
+	if sb.FeaturesIncompatible&(FeatureIncompatChecksumV2|FeatureIncompatChecksumV3) != 0 {
+		binary.BigEndian.PutUint32(sbData[0xFC:], 0)
+		binary.BigEndian.PutUint32(sbData[0xFC:], ^crc32.Checksum(sbData, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	journal.Device.Write(int64(journal.Blocks[0]*sb.BlockSize), sbData)
+	return replayed, nil
+}
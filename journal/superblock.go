@@ -0,0 +1,118 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	Magic = 0xC03B3998
+
+	BlockTypeDescriptor   = 1
+	BlockTypeCommit       = 2
+	BlockTypeSuperblockV1 = 3
+	BlockTypeSuperblockV2 = 4
+	BlockTypeRevoke       = 5
+
+	// Inode is the reserved inode number of an internal journal.
+	Inode = 8
+
+	MinBlocks = 1024
+)
+
+// Superblock is the JBD2 journal superblock stored in the first block of
+// the journal. Unlike the rest of the filesystem, it's big-endian.
+type Superblock struct {
+	BlockType                  int
+	BlockSize                  int
+	MaxLen                     int
+	First                      int
+	Sequence                   int
+	Start                      int
+	Errno                      int
+	FeaturesCompatible         int
+	FeaturesIncompatible       int
+	FeaturesReadOnlyCompatible int
+	Uuid                       [16]byte
+	NumUsers                   int
+}
+
+func NewSuperblock(blockSize, numBlocks int, uuid [16]byte) *Superblock {
+	return &Superblock{
+		BlockType: BlockTypeSuperblockV2,
+		BlockSize: blockSize,
+		MaxLen:    numBlocks,
+		First:     1,
+		Sequence:  1,
+		Start:     0,
+		Uuid:      uuid,
+		NumUsers:  1,
+	}
+}
+
+func (superblock *Superblock) Encode() []byte {
+	be := binary.BigEndian
+	data := make([]byte, 1024)
+	be.PutUint32(data[0:], Magic)
+	be.PutUint32(data[4:], uint32(superblock.BlockType))
+	be.PutUint32(data[12:], uint32(superblock.BlockSize))
+	be.PutUint32(data[16:], uint32(superblock.MaxLen))
+	be.PutUint32(data[20:], uint32(superblock.First))
+	be.PutUint32(data[24:], uint32(superblock.Sequence))
+	be.PutUint32(data[28:], uint32(superblock.Start))
+	be.PutUint32(data[32:], uint32(superblock.Errno))
+	if superblock.BlockType == BlockTypeSuperblockV2 {
+		be.PutUint32(data[36:], uint32(superblock.FeaturesCompatible))
+		be.PutUint32(data[40:], uint32(superblock.FeaturesIncompatible))
+		be.PutUint32(data[44:], uint32(superblock.FeaturesReadOnlyCompatible))
+		copy(data[48:64], superblock.Uuid[:])
+		be.PutUint32(data[64:], uint32(superblock.NumUsers))
+	}
+	return data
+}
+
+func DecodeSuperblock(data []byte) (*Superblock, error) {
+	be := binary.BigEndian
+	if len(data) < 1024 || be.Uint32(data[0:]) != Magic {
+		return nil, errors.New("invalid journal superblock magic number")
+	}
+	superblock := &Superblock{
+		BlockType: int(be.Uint32(data[4:])),
+		BlockSize: int(be.Uint32(data[12:])),
+		MaxLen:    int(be.Uint32(data[16:])),
+		First:     int(be.Uint32(data[20:])),
+		Sequence:  int(be.Uint32(data[24:])),
+		Start:     int(be.Uint32(data[28:])),
+		Errno:     int(int32(be.Uint32(data[32:]))),
+	}
+	switch superblock.BlockType {
+	case BlockTypeSuperblockV1:
+	case BlockTypeSuperblockV2:
+		superblock.FeaturesCompatible = int(be.Uint32(data[36:]))
+		superblock.FeaturesIncompatible = int(be.Uint32(data[40:]))
+		superblock.FeaturesReadOnlyCompatible = int(be.Uint32(data[44:]))
+		copy(superblock.Uuid[:], data[48:64])
+		superblock.NumUsers = int(be.Uint32(data[64:]))
+	default:
+		return nil, errors.New("invalid journal superblock type")
+	}
+	return superblock, nil
+}
+
+// DefaultSize returns the number of journal blocks mke2fs picks for a
+// filesystem of numBlocks blocks, or 0 if it's too small for a journal.
+func DefaultSize(numBlocks int) int {
+	switch {
+	case numBlocks < 2048:
+		return 0
+	case numBlocks < 32768:
+		return 1024
+	case numBlocks < 256*1024:
+		return 4096
+	case numBlocks < 512*1024:
+		return 8192
+	case numBlocks < 4096*1024:
+		return 16384
+	}
+	return 32768
+}
@@ -1,20 +1,69 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 
+	"github.com/ErrorNoInternet/mkfs.ext2/capability"
+	"github.com/ErrorNoInternet/mkfs.ext2/device"
+	"github.com/ErrorNoInternet/mkfs.ext2/devtable"
 	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+	"github.com/ErrorNoInternet/mkfs.ext2/selinux"
 )
 
 func main() {
-	var devicePath string
-	var blockSize, blocks int
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build":
+			buildCommand(os.Args[2:])
+			return
+		case "tune":
+			tuneCommand(os.Args[2:])
+			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		}
+	}
+
+	var devicePath, features, extendedOptions, journalOptions, rootDirectory, fileContextsPath, capabilitiesPath, deviceTablePath, badBlocksPath, mountOptions, reservedPercent, checkInterval, errorBehavior, seed, creationTime, label, volumeId, lastMountPath string
+	var blockSize, blocks, inodeSize, inodeRatio, revision, maxMountCount, mountCount int
+	var journal, scan, scanReadWrite, quiet bool
+	var scanBatchSize int
 	flag.StringVar(&devicePath, "device", "", "The device you want to create a filesystem on")
 	flag.IntVar(&blockSize, "blockSize", 4096, "The size (in bytes) of each block in the filesystem")
 	flag.IntVar(&blocks, "blocks", 0, "The amount of blocks to create in the filesystem")
+	flag.IntVar(&inodeSize, "I", 128, "The size (in bytes) of each inode in the filesystem")
+	flag.IntVar(&revision, "r", 1, "The filesystem revision: 1, or 0 for old loaders (no features and 128-byte inodes)")
+	flag.IntVar(&inodeRatio, "i", 0, "The number of bytes per inode (default: one inode per block)")
+	flag.StringVar(&reservedPercent, "m", "5", "The percentage of blocks reserved for the reserved user and group (-E resuid, resgid)")
+	flag.IntVar(&maxMountCount, "max-mount-count", 25, "The number of mounts after which the filesystem is checked (-1 to disable)")
+	flag.IntVar(&mountCount, "C", 0, "The number of mounts since the last check")
+	flag.StringVar(&checkInterval, "check-interval", "180d", "The maximum time between checks in days, or with a d, w or m suffix (0 to disable)")
+	flag.StringVar(&mountOptions, "default-mount-options", "", "Comma separated default mount options: acl, user_xattr, journal_data, nobarrier, ...")
+	flag.StringVar(&errorBehavior, "e", "continue", "The behavior on errors: continue, remount-ro or panic")
+	flag.StringVar(&features, "O", "", "Comma separated features to enable (or disable with a ^ prefix): dir_index, ext_attr, has_journal, large_file, uninit_bg")
+	flag.StringVar(&extendedOptions, "E", "", "Comma separated extended options: hash_alg=legacy|half_md4|tea, resuid=uid, resgid=gid, root_owner[=uid:gid], mount_opts=options, lazy_itable_init[=0|1]")
+	flag.BoolVar(&journal, "j", false, "Create an ext3 journal")
+	flag.StringVar(&journalOptions, "J", "", "Comma separated journal options: size=MiB")
+	flag.StringVar(&badBlocksPath, "l", "", "A file of bad block numbers (one per line) that are never used")
+	flag.BoolVar(&scan, "c", false, "Check the device for bad blocks by reading every block")
+	flag.BoolVar(&scanReadWrite, "cc", false, "Check the device for bad blocks by writing test patterns to every block, keeping its contents")
+	flag.IntVar(&scanBatchSize, "scan-batch", 64, "The number of blocks checked for bad blocks at a time")
+	flag.StringVar(&rootDirectory, "d", "", "A directory whose contents are copied into the filesystem")
+	flag.StringVar(&deviceTablePath, "D", "", "A genext2fs device table of devices to create and files to change the mode and owner of")
+	flag.StringVar(&fileContextsPath, "file-contexts", "", "A file_contexts file used to set the SELinux context of every file")
+	flag.StringVar(&capabilitiesPath, "capabilities", "", "A manifest of file capabilities (path followed by capabilities, e.g. cap_net_raw+ep)")
+	flag.StringVar(&label, "L", "", "The volume label (at most 16 bytes)")
+	flag.StringVar(&volumeId, "U", "", "The volume UUID: a UUID, random, time or clear (default: random)")
+	flag.StringVar(&lastMountPath, "M", "", "The directory the filesystem was last mounted on")
+	flag.StringVar(&seed, "seed", "", "A seed the UUID and hash seed are derived from instead of being random")
+	flag.BoolVar(&quiet, "q", false, "Don't show the progress of making the filesystem")
+	flag.StringVar(&creationTime, "time", "", "Seconds since the epoch used for every timestamp (default: $SOURCE_DATE_EPOCH or the current time)")
 	flag.Parse()
 
 	if devicePath == "" {
@@ -22,6 +71,126 @@ func main() {
 		return
 	}
 
+	options := filesystem.DefaultOptions()
+	options.InodeSize = inodeSize
+	options.InodeRatio = inodeRatio
+	options.Revision = revision
+	if revision == 0 {
+		options.DirIndex = false
+		if fileContextsPath != "" || capabilitiesPath != "" {
+			fmt.Println("error: revision 0 filesystems don't support extended attributes")
+			return
+		}
+	}
+	options.Label = label
+	options.LastMountPath = lastMountPath
+	options.Seed = seed
+	var err error
+	if options.ReservedPercent, err = parseReservedPercent(reservedPercent); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	options.MaxMountCount = maxMountCount
+	options.MountCount = mountCount
+	if options.CheckInterval, err = parseInterval(checkInterval); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if options.ErrorBehavior, err = parseErrorBehavior(errorBehavior); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if options.DefaultMountOptions, err = parseMountOptions(mountOptions, 0); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if badBlocksPath != "" {
+		if options.BadBlocks, err = loadBadBlocks(badBlocksPath); err != nil {
+			fmt.Printf("unable to load bad blocks: %v\n", err)
+			return
+		}
+	}
+	if scanReadWrite {
+		options.Scan = device.ScanReadWrite
+	} else if scan {
+		options.Scan = device.ScanReadOnly
+	}
+	if scanBatchSize < 1 {
+		fmt.Println("error: unsupported scan batch size specified")
+		return
+	}
+	options.ScanBatchSize = scanBatchSize
+	bar := &progressBar{}
+	if !quiet {
+		options.Progress = bar.Update
+	}
+	if options.Time, err = parseTime(creationTime); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if volumeId != "" {
+		if options.Uuid, err = parseUuid(volumeId); err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+	}
+	if err := parseFeatures(features, options); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if err := parseExtendedOptions(extendedOptions, options); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if journal {
+		options.Journal = true
+	}
+	if err := parseJournalOptions(journalOptions, blockSize, options); err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+
+	if rootDirectory != "" {
+		information, err := os.Stat(rootDirectory)
+		if err != nil {
+			fmt.Printf("unable to read root directory: %v\n", err)
+			os.Exit(1)
+		} else if !information.IsDir() {
+			fmt.Printf("error: %v isn't a directory\n", rootDirectory)
+			os.Exit(1)
+		}
+	}
+
+	var deviceTable []*devtable.Entry
+	if deviceTablePath != "" {
+		var err error
+		deviceTable, err = devtable.Load(deviceTablePath)
+		if err != nil {
+			fmt.Printf("unable to load device table: %v\n", err)
+			return
+		}
+	}
+
+	var fileContexts *selinux.FileContexts
+	if fileContextsPath != "" {
+		var err error
+		fileContexts, err = selinux.LoadFileContexts(fileContextsPath)
+		if err != nil {
+			fmt.Printf("unable to load file contexts: %v\n", err)
+			return
+		}
+	}
+
+	var capabilities capability.Manifest
+	if capabilitiesPath != "" {
+		var err error
+		capabilities, err = capability.LoadManifest(capabilitiesPath)
+		if err != nil {
+			fmt.Printf("unable to load capabilities: %v\n", err)
+			return
+		}
+	}
+
 	if blocks == 0 {
 		deviceInformation, err := os.Stat(devicePath)
 		if err != nil {
@@ -48,8 +217,52 @@ func main() {
 		fmt.Printf("unable to create file: %v\n", err)
 		return
 	}
-	err = filesystem.Make(file, blockSize, blocks)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = filesystem.Make(ctx, file, blockSize, blocks, options)
+	bar.Finish()
 	if err != nil {
 		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if rootDirectory != "" || deviceTable != nil || fileContexts != nil || capabilities != nil {
+		file, err = os.OpenFile(devicePath, os.O_RDWR, 0)
+		if err != nil {
+			fmt.Printf("unable to open file: %v\n", err)
+			os.Exit(1)
+		}
+		fs, err := filesystem.Open(file)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(1)
+		}
+		fail := func(message string, err error) {
+			fs.Close()
+			fmt.Printf("%v: %v\n", message, err)
+			os.Exit(1)
+		}
+		fs.SetTime(options.Time)
+		if rootDirectory != "" {
+			if err = fs.Populate(rootDirectory); err != nil {
+				fail("unable to populate filesystem", err)
+			}
+		}
+		if deviceTable != nil {
+			if err = fs.ApplyDeviceTable(deviceTable); err != nil {
+				fail("unable to apply device table", err)
+			}
+		}
+		if fileContexts != nil {
+			if err = fs.Relabel(fileContexts); err != nil {
+				fail("unable to label filesystem", err)
+			}
+		}
+		if capabilities != nil {
+			if err = fs.ApplyCapabilities(capabilities); err != nil {
+				fail("unable to set capabilities", err)
+			}
+		}
+		fs.Close()
 	}
 }
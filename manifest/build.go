@@ -0,0 +1,159 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+var specialTypes = map[string]int{
+	"char":   inode.ModeCharDevice,
+	"block":  inode.ModeBlockDevice,
+	"fifo":   inode.ModeFifo,
+	"socket": inode.ModeSocket,
+}
+
+// Build creates the filesystem described by the manifest on devicePath
+// and adds all of its entries. progress, if it isn't nil, is called as the
+// filesystem is made, which stops once ctx is cancelled.
+func (manifest *Manifest) Build(ctx context.Context, devicePath string, progress func(phase string, done, total int)) error {
+	options, err := manifest.Options()
+	if err != nil {
+		return err
+	}
+	options.Progress = progress
+	file, err := os.Create(devicePath)
+	if err != nil {
+		return err
+	}
+	if err = filesystem.Make(ctx, file, manifest.BlockSize, manifest.Blocks, options); err != nil {
+		return err
+	}
+
+	file, err = os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	fs, err := filesystem.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	fs.SetTime(options.Time)
+	for _, entry := range manifest.Entries {
+		if err = manifest.addEntry(fs, &entry); err != nil {
+			return errors.New(entry.Path + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (manifest *Manifest) addEntry(fs *filesystem.Filesystem, entry *Entry) error {
+	if path.Clean("/"+entry.Path) == "/" && entry.Type != "directory" {
+		return errors.New("the root can only be a directory")
+	}
+	if err := fs.MkdirAll(path.Dir(path.Clean("/"+entry.Path)), 0755); err != nil {
+		return err
+	}
+
+	var err error
+	var mode int
+	switch entry.Type {
+	case "file":
+		if mode, err = entry.mode(0644); err != nil {
+			return err
+		}
+		err = manifest.writeFile(fs, entry)
+	case "directory":
+		if mode, err = entry.mode(0755); err != nil {
+			return err
+		}
+		err = fs.MkdirAll(entry.Path, mode)
+	case "symlink":
+		return manifest.setAttributes(fs, entry, -1, fs.Symlink(entry.Target, entry.Path))
+	case "hardlink":
+		return fs.Link(entry.Target, entry.Path)
+	case "char", "block", "fifo", "socket":
+		if mode, err = entry.mode(0600); err != nil {
+			return err
+		}
+		err = fs.Mknod(entry.Path, specialTypes[entry.Type]|mode, entry.Major, entry.Minor)
+	default:
+		return errors.New("unknown entry type: " + entry.Type)
+	}
+	return manifest.setAttributes(fs, entry, mode, err)
+}
+
+// writeFile creates a regular file with the contents of the host file
+// entry.Source or with entry.Content.
+func (manifest *Manifest) writeFile(fs *filesystem.Filesystem, entry *Entry) error {
+	if entry.Source != "" && entry.Content != "" {
+		return errors.New("file has both a source and content")
+	}
+	target, err := fs.Create(entry.Path, 0600)
+	if err != nil {
+		return err
+	}
+	if entry.Source == "" {
+		_, err = io.Copy(target, strings.NewReader(entry.Content))
+		return err
+	}
+	sourcePath := entry.Source
+	if !filepath.IsAbs(sourcePath) {
+		sourcePath = filepath.Join(manifest.directory, sourcePath)
+	}
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = io.Copy(target, source)
+	return err
+}
+
+// setAttributes applies the mode (unless it's -1), ownership, extended
+// attributes and timestamps of an entry once it has been created without
+// an error.
+func (manifest *Manifest) setAttributes(fs *filesystem.Filesystem, entry *Entry, mode int, err error) error {
+	if err != nil {
+		return err
+	}
+	if mode != -1 {
+		if err = fs.Chmod(entry.Path, mode); err != nil {
+			return err
+		}
+	}
+	if err = fs.Chown(entry.Path, entry.Uid, entry.Gid); err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range entry.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := xattrValue(entry.Xattrs[name])
+		if err != nil {
+			return errors.New("invalid value of " + name)
+		}
+		if err = fs.Setxattr(entry.Path, name, value); err != nil {
+			return err
+		}
+	}
+	if entry.Mtime != nil {
+		accessTime := *entry.Mtime
+		if entry.Atime != nil {
+			accessTime = *entry.Atime
+		}
+		return fs.Chtimes(entry.Path, accessTime, *entry.Mtime)
+	}
+	return nil
+}
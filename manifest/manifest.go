@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+	"github.com/ErrorNoInternet/mkfs.ext2/htree"
+	"github.com/google/uuid"
+)
+
+// Manifest describes a whole image: the geometry and features of the
+// filesystem and the files in it. Seed and Time make the image
+// reproducible, see filesystem.Options. Entries are created in order, along
+// with any missing parent directories.
+type Manifest struct {
+	BlockSize     int        `json:"blockSize"`
+	Blocks        int        `json:"blocks"`
+	InodeSize     int        `json:"inodeSize"`
+	InodeRatio    int        `json:"inodeRatio"`
+	Label         string     `json:"label"`
+	Uuid          string     `json:"uuid"`
+	Features      []string   `json:"features"`
+	HashAlgorithm string     `json:"hashAlgorithm"`
+	JournalSize   int        `json:"journalSize"`
+	Seed          string     `json:"seed"`
+	Time          *time.Time `json:"time"`
+	Entries       []Entry    `json:"entries"`
+
+	// directory is where host paths of entries are relative to.
+	directory string
+}
+
+// Entry is a single file of the image. Type is one of "file",
+// "directory", "symlink", "hardlink", "char", "block", "fifo" or
+// "socket". Files are copied from the host path Source or hold Content,
+// while Target is the target of a symlink or the existing path in the
+// image a hard link points to.
+type Entry struct {
+	Path    string            `json:"path"`
+	Type    string            `json:"type"`
+	Source  string            `json:"source"`
+	Content string            `json:"content"`
+	Target  string            `json:"target"`
+	Mode    string            `json:"mode"`
+	Uid     int               `json:"uid"`
+	Gid     int               `json:"gid"`
+	Major   int               `json:"major"`
+	Minor   int               `json:"minor"`
+	Atime   *time.Time        `json:"atime"`
+	Mtime   *time.Time        `json:"mtime"`
+	Xattrs  map[string]string `json:"xattrs"`
+}
+
+// Parse reads a JSON manifest. Host paths are relative to directory.
+func Parse(reader io.Reader, directory string) (*Manifest, error) {
+	manifest := &Manifest{
+		BlockSize: 4096,
+		directory: directory,
+	}
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Blocks <= 0 {
+		return nil, errors.New("manifest is missing the number of blocks")
+	}
+	return manifest, nil
+}
+
+func Load(filePath string) (*Manifest, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file, filepath.Dir(filePath))
+}
+
+// Options returns the filesystem options the manifest asks for.
+func (manifest *Manifest) Options() (*filesystem.Options, error) {
+	options := filesystem.DefaultOptions()
+	if manifest.InodeSize != 0 {
+		options.InodeSize = manifest.InodeSize
+	}
+	options.InodeRatio = manifest.InodeRatio
+	options.Label = manifest.Label
+	options.Seed = manifest.Seed
+	if manifest.Time != nil {
+		options.Time = *manifest.Time
+	}
+	if manifest.Uuid != "" {
+		volumeId, err := uuid.Parse(manifest.Uuid)
+		if err != nil {
+			return nil, err
+		}
+		options.Uuid = &volumeId
+	}
+	for _, feature := range manifest.Features {
+		enable := !strings.HasPrefix(feature, "^")
+		if err := options.SetFeature(strings.TrimPrefix(feature, "^"), enable); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.HashAlgorithm != "" {
+		hashVersion, err := htree.HashVersionByName(manifest.HashAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		options.HashVersion = hashVersion
+	}
+	if manifest.JournalSize != 0 {
+		options.Journal = true
+		options.JournalBlocks = manifest.JournalSize * 1024 * 1024 / manifest.BlockSize
+	}
+	return options, nil
+}
+
+// mode returns the permission bits of an entry, or fallback if the entry
+// doesn't have any.
+func (entry *Entry) mode(fallback int) (int, error) {
+	if entry.Mode == "" {
+		return fallback, nil
+	}
+	mode, err := strconv.ParseUint(entry.Mode, 8, 12)
+	if err != nil {
+		return 0, errors.New("invalid mode: " + entry.Mode)
+	}
+	return int(mode), nil
+}
+
+// xattrValue decodes an attribute value the way setfattr does: values
+// starting with "0x" are hexadecimal, values starting with "0s" are base64
+// and everything else is text.
+func xattrValue(value string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(value, "0x"):
+		return hex.DecodeString(value[2:])
+	case strings.HasPrefix(value, "0s"):
+		return base64.StdEncoding.DecodeString(value[2:])
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+	"github.com/ErrorNoInternet/mkfs.ext2/htree"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+	"github.com/google/uuid"
+)
+
+// parseTime parses a time given in seconds since the epoch. Without one,
+// the SOURCE_DATE_EPOCH environment variable is used, and the zero time
+// (meaning the current time) is returned if that isn't set either.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		value = os.Getenv("SOURCE_DATE_EPOCH")
+		if value == "" {
+			return time.Time{}, nil
+		}
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("invalid time: " + value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// parseUuid parses the volume id given to -U, which is either a UUID,
+// "random", "time" for a time-based one or "clear" for the nil UUID.
+func parseUuid(value string) (*uuid.UUID, error) {
+	var volumeId uuid.UUID
+	var err error
+	switch value {
+	case "random":
+		volumeId = uuid.New()
+	case "time":
+		volumeId, err = uuid.NewUUID()
+	case "clear":
+		volumeId = uuid.Nil
+	default:
+		volumeId, err = uuid.Parse(value)
+		if err != nil {
+			return nil, errors.New("invalid UUID: " + value)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &volumeId, nil
+}
+
+// parseInterval parses a check interval as a number of days, or of
+// seconds, days, weeks or months with an s, d, w or m suffix, and returns
+// it in seconds.
+func parseInterval(value string) (int64, error) {
+	units := map[byte]int64{'s': 1, 'd': 24 * 60 * 60, 'w': 7 * 24 * 60 * 60, 'm': 30 * 24 * 60 * 60}
+	unit, number := units['d'], value
+	if value != "" && (value[len(value)-1] < '0' || value[len(value)-1] > '9') {
+		unit, number = units[value[len(value)-1]], value[:len(value)-1]
+	}
+	interval, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || unit == 0 || interval < 0 || interval > 0xFFFFFFFF/unit {
+		return 0, errors.New("invalid interval: " + value)
+	}
+	return interval * unit, nil
+}
+
+func parseErrorBehavior(value string) (int, error) {
+	errorAction, ok := superblock.ErrorBehaviorNames[value]
+	if !ok {
+		return 0, errors.New("invalid error behavior: " + value)
+	}
+	return errorAction, nil
+}
+
+// parseReservedPercent parses the percentage of blocks reserved for the
+// reserved user and group, which may be a fraction.
+func parseReservedPercent(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 50 {
+		return 0, errors.New("invalid reserved blocks percentage: " + value)
+	}
+	return percent, nil
+}
+
+// parseMountOptions applies a comma separated list of default mount
+// options to mountOptions. An option prefixed with "^" is turned off.
+func parseMountOptions(list string, mountOptions int) (int, error) {
+	for _, option := range strings.Split(list, ",") {
+		if option == "" {
+			continue
+		}
+		enable := !strings.HasPrefix(option, "^")
+		value, ok := superblock.MountOptionNames[strings.TrimPrefix(option, "^")]
+		if !ok {
+			return 0, errors.New("unknown mount option: " + option)
+		}
+		mask := value
+		if value&superblock.MountOptJournalMask != 0 {
+			mask = superblock.MountOptJournalMask
+		}
+		mountOptions &^= mask
+		if enable {
+			mountOptions |= value
+		}
+	}
+	return mountOptions, nil
+}
+
+// parseId parses a user or group id.
+func parseId(value string) (int, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	return int(id), err
+}
+
+// loadBadBlocks reads a list of bad block numbers, one per line, like the
+// one badblocks writes.
+func loadBadBlocks(filePath string) ([]int, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	badBlocks := []int{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		bid, err := strconv.ParseUint(line, 10, 32)
+		if err != nil {
+			return nil, errors.New("invalid bad block: " + line)
+		}
+		badBlocks = append(badBlocks, int(bid))
+	}
+	return badBlocks, nil
+}
+
+// parseFeatures applies a comma separated list of features to options. A
+// feature prefixed with "^" is turned off.
+func parseFeatures(list string, options *filesystem.Options) error {
+	for _, feature := range strings.Split(list, ",") {
+		if feature == "" {
+			continue
+		}
+		enable := !strings.HasPrefix(feature, "^")
+		if err := options.SetFeature(strings.TrimPrefix(feature, "^"), enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExtendedOptions applies a comma separated list of key=value
+// extended options to options.
+func parseExtendedOptions(list string, options *filesystem.Options) error {
+	for _, option := range strings.Split(list, ",") {
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "root_owner":
+			uid, gid := os.Getuid(), os.Getgid()
+			if value != "" {
+				uidValue, gidValue, _ := strings.Cut(value, ":")
+				var err error
+				if uid, err = parseId(uidValue); err != nil {
+					return errors.New("invalid root owner: " + value)
+				}
+				if gid, err = parseId(gidValue); err != nil {
+					return errors.New("invalid root owner: " + value)
+				}
+			}
+			options.RootUid, options.RootGid = uid, gid
+		case "resuid", "resgid":
+			id, err := parseId(value)
+			if err != nil || id > 0xFFFF {
+				return errors.New("invalid " + key + ": " + value)
+			}
+			if key == "resuid" {
+				options.ReservedUid = id
+			} else {
+				options.ReservedGid = id
+			}
+		case "mount_opts":
+			options.MountOptions = value
+		case "lazy_itable_init":
+			switch value {
+			case "", "1":
+				options.LazyItableInit = true
+			case "0":
+				options.LazyItableInit = false
+			default:
+				return errors.New("invalid lazy_itable_init: " + value)
+			}
+		case "hash_alg":
+			hashVersion, err := htree.HashVersionByName(value)
+			if err != nil {
+				return err
+			}
+			options.HashVersion = hashVersion
+		default:
+			return errors.New("unknown extended option: " + option)
+		}
+	}
+	return nil
+}
+
+// parseJournalOptions applies a comma separated list of journal options
+// to options. The journal size is given in MiB.
+func parseJournalOptions(list string, blockSize int, options *filesystem.Options) error {
+	for _, option := range strings.Split(list, ",") {
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "size":
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return errors.New("invalid journal size: " + value)
+			}
+			options.Journal = true
+			options.JournalBlocks = size * 1024 * 1024 / blockSize
+		default:
+			return errors.New("unknown journal option: " + option)
+		}
+	}
+	return nil
+}
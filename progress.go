@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const progressBarWidth = 40
+
+// progressBar draws the progress of each phase on a single line of the
+// terminal, which is only redrawn when the percentage changes.
+type progressBar struct {
+	phase   string
+	percent int
+	done    bool
+}
+
+// Update shows how much of phase is done, finishing the line once the
+// whole phase is.
+func (bar *progressBar) Update(phase string, done, total int) {
+	percent := 100
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	if phase != bar.phase {
+		bar.Finish()
+	} else if percent == bar.percent {
+		return
+	}
+	bar.phase = phase
+	bar.percent = percent
+	bar.done = false
+
+	filled := percent * progressBarWidth / 100
+	fmt.Printf(
+		"\r%s [%s%s] %3d%%",
+		phase,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled),
+		percent,
+	)
+	if done == total {
+		bar.Finish()
+	}
+}
+
+// Finish ends the line of an unfinished phase, so that anything printed
+// afterwards starts on a new one.
+func (bar *progressBar) Finish() {
+	if bar.phase != "" && !bar.done {
+		fmt.Println()
+	}
+	bar.done = true
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+)
+
+func replayCommand(arguments []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	var devicePath string
+	flags.StringVar(&devicePath, "device", "", "The device whose journal you want to replay")
+	flags.Parse(arguments)
+
+	if devicePath == "" {
+		flags.Usage()
+		return
+	}
+
+	file, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Printf("unable to open file: %v\n", err)
+		return
+	}
+	replayed, err := filesystem.Replay(file)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	fmt.Printf("replayed %v transactions\n", replayed)
+}
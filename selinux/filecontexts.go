@@ -0,0 +1,129 @@
+package selinux
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/inode"
+)
+
+const (
+	// XattrName is the extended attribute holding the context of a file.
+	XattrName = "security.selinux"
+
+	// NoContext is the context of specs that leave matching files
+	// unlabeled.
+	NoContext = "<<none>>"
+)
+
+var fileTypes = map[string]int{
+	"--": inode.ModeRegular,
+	"-d": inode.ModeDirectory,
+	"-l": inode.ModeSymlink,
+	"-c": inode.ModeCharDevice,
+	"-b": inode.ModeBlockDevice,
+	"-s": inode.ModeSocket,
+	"-p": inode.ModeFifo,
+}
+
+// Spec is a single line of a file_contexts file. FileType is the inode
+// type the spec is limited to, or 0 if it applies to every file.
+type Spec struct {
+	Pattern  string
+	FileType int
+	Context  string
+
+	regexp  *regexp.Regexp
+	hasMeta bool
+}
+
+// FileContexts maps paths to SELinux contexts the way libselinux does:
+// specs without regular expression characters take precedence over those
+// with them, and otherwise the last matching spec wins.
+type FileContexts struct {
+	Specs []*Spec
+}
+
+// hasMetaChars reports whether a pattern is a regular expression rather
+// than a plain path.
+func hasMetaChars(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '.', '^', '$', '?', '*', '+', '|', '[', '(', '{':
+			return true
+		case '\\':
+			i++
+		}
+	}
+	return false
+}
+
+func ParseFileContexts(reader io.Reader) (*FileContexts, error) {
+	fileContexts := &FileContexts{}
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		spec := &Spec{Pattern: fields[0]}
+		switch len(fields) {
+		case 2:
+			spec.Context = fields[1]
+		case 3:
+			fileType, ok := fileTypes[fields[1]]
+			if !ok {
+				return nil, errors.New("invalid file type on line " + strconv.Itoa(lineNum) + ": " + fields[1])
+			}
+			spec.FileType = fileType
+			spec.Context = fields[2]
+		default:
+			return nil, errors.New("invalid spec on line " + strconv.Itoa(lineNum))
+		}
+		re, err := regexp.Compile("^(?:" + spec.Pattern + ")$")
+		if err != nil {
+			return nil, errors.New("invalid regular expression on line " + strconv.Itoa(lineNum) + ": " + err.Error())
+		}
+		spec.regexp = re
+		spec.hasMeta = hasMetaChars(spec.Pattern)
+		fileContexts.Specs = append(fileContexts.Specs, spec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(fileContexts.Specs, func(i, j int) bool {
+		return fileContexts.Specs[i].hasMeta && !fileContexts.Specs[j].hasMeta
+	})
+	return fileContexts, nil
+}
+
+func LoadFileContexts(filePath string) (*FileContexts, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseFileContexts(file)
+}
+
+// Lookup returns the context of the file at filePath with the given mode,
+// or false if it shouldn't be labeled.
+func (fileContexts *FileContexts) Lookup(filePath string, mode int) (string, bool) {
+	for i := len(fileContexts.Specs) - 1; i >= 0; i-- {
+		spec := fileContexts.Specs[i]
+		if spec.FileType != 0 && spec.FileType != mode&inode.ModeTypeMask {
+			continue
+		}
+		if spec.regexp.MatchString(filePath) {
+			return spec.Context, spec.Context != NoContext
+		}
+	}
+	return "", false
+}
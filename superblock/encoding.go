@@ -0,0 +1,287 @@
+package superblock
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Size is the size of the on-disk superblock.
+const Size = 1024
+
+// ErrorRecord describes an error the kernel found in the filesystem
+// (s_first_error_* and s_last_error_*).
+type ErrorRecord struct {
+	Time     int64
+	Inode    int
+	Block    int64
+	Function string
+	Line     int
+	Code     int
+}
+
+func cString(data []byte) string {
+	for index, char := range data {
+		if char == 0 {
+			return string(data[:index])
+		}
+	}
+	return string(data)
+}
+
+// splitTime returns the low 32 bits of a timestamp and the 8 bits stored
+// in the matching _hi field.
+func splitTime(timestamp int64) (uint32, byte) {
+	return uint32(timestamp), byte(timestamp >> 32)
+}
+
+func joinTime(low uint32, high byte) int64 {
+	return int64(low) | int64(high)<<32
+}
+
+func encodeErrorRecord(data []byte, record *ErrorRecord, timeOffset, inodeOffset, blockOffset, functionOffset, lineOffset int) (byte, byte) {
+	le := binary.LittleEndian
+	low, high := splitTime(record.Time)
+	le.PutUint32(data[timeOffset:], low)
+	le.PutUint32(data[inodeOffset:], uint32(record.Inode))
+	le.PutUint64(data[blockOffset:], uint64(record.Block))
+	copy(data[functionOffset:functionOffset+32], record.Function)
+	le.PutUint32(data[lineOffset:], uint32(record.Line))
+	return high, byte(record.Code)
+}
+
+func decodeErrorRecord(data []byte, timeHigh, code byte, timeOffset, inodeOffset, blockOffset, functionOffset, lineOffset int) ErrorRecord {
+	le := binary.LittleEndian
+	return ErrorRecord{
+		Time:     joinTime(le.Uint32(data[timeOffset:]), timeHigh),
+		Inode:    int(le.Uint32(data[inodeOffset:])),
+		Block:    int64(le.Uint64(data[blockOffset:])),
+		Function: cString(data[functionOffset : functionOffset+32]),
+		Line:     int(le.Uint32(data[lineOffset:])),
+		Code:     int(code),
+	}
+}
+
+// Encode returns the on-disk form of every field of the superblock.
+func (superblock *Superblock) Encode() []byte {
+	data := make([]byte, Size)
+	le := binary.LittleEndian
+	le.PutUint32(data[0:], uint32(superblock.NumInodes))
+	le.PutUint32(data[4:], uint32(superblock.NumBlocks))
+	le.PutUint32(data[8:], uint32(superblock.NumResBlocks))
+	le.PutUint32(data[12:], uint32(superblock.NumFreeBlocks))
+	le.PutUint32(data[16:], uint32(superblock.NumFreeInodes))
+	le.PutUint32(data[20:], uint32(superblock.FirstBlockId))
+	le.PutUint32(data[24:], uint32(superblock.LogBlockSize))
+	le.PutUint32(data[28:], uint32(superblock.LogFragSize))
+	le.PutUint32(data[32:], uint32(superblock.NumBlocksPerGroup))
+	le.PutUint32(data[36:], uint32(superblock.NumFragsPerGroup))
+	le.PutUint32(data[40:], uint32(superblock.NumInodesPerGroup))
+	mountTime, mountTimeHigh := splitTime(superblock.TimeLastMount)
+	le.PutUint32(data[44:], mountTime)
+	writeTime, writeTimeHigh := splitTime(superblock.TimeLastWrite)
+	le.PutUint32(data[48:], writeTime)
+	le.PutUint16(data[52:], uint16(superblock.NumMountsSinceCheck))
+	le.PutUint16(data[54:], uint16(superblock.NumMountsMax))
+	le.PutUint16(data[56:], uint16(superblock.MagicNum))
+	le.PutUint16(data[58:], uint16(superblock.State))
+	le.PutUint16(data[60:], uint16(superblock.ErrorAction))
+	le.PutUint16(data[62:], uint16(superblock.RevMinor))
+	checkTime, checkTimeHigh := splitTime(superblock.TimeLastCheck)
+	le.PutUint32(data[64:], checkTime)
+	le.PutUint32(data[68:], uint32(superblock.TimeBetweenCheck))
+	le.PutUint32(data[72:], uint32(superblock.CreatorOs))
+	le.PutUint32(data[76:], uint32(superblock.RevLevel))
+	le.PutUint16(data[80:], uint16(superblock.DefResUid))
+	le.PutUint16(data[82:], uint16(superblock.DefResGid))
+
+	// The first inode, inode size and features are fixed in revision 0,
+	// so their fields are left zero.
+	if superblock.RevLevel > 0 {
+		le.PutUint32(data[84:], uint32(superblock.FirstInodeIndex))
+		le.PutUint16(data[88:], uint16(superblock.InodeSize))
+		le.PutUint16(data[90:], uint16(superblock.BgNum))
+		le.PutUint32(data[92:], uint32(superblock.FeaturesCompatible))
+		le.PutUint32(data[96:], uint32(superblock.FeaturesIncompatible))
+		le.PutUint32(data[100:], uint32(superblock.FeaturesReadOnlyCompatible))
+	}
+	copy(data[104:120], superblock.VolumeId[:])
+	copy(data[120:136], superblock.VolumeName)
+	copy(data[136:200], superblock.LastMountPath)
+	le.PutUint32(data[200:], uint32(superblock.AlgorithmUsageBitmap))
+
+	data[204] = byte(superblock.PreallocBlocks)
+	data[205] = byte(superblock.PreallocDirBlocks)
+	le.PutUint16(data[206:], uint16(superblock.ReservedGdtBlocks))
+
+	copy(data[208:224], superblock.JournalUuid[:])
+	le.PutUint32(data[224:], uint32(superblock.JournalInode))
+	le.PutUint32(data[228:], uint32(superblock.JournalDevice))
+	le.PutUint32(data[232:], uint32(superblock.LastOrphan))
+	copy(data[236:252], superblock.HashSeed[:])
+	data[252] = byte(superblock.DefaultHashVersion)
+	data[253] = byte(superblock.JournalBackupType)
+	le.PutUint16(data[254:], uint16(superblock.DescSize))
+	le.PutUint32(data[256:], uint32(superblock.DefaultMountOptions))
+	le.PutUint32(data[260:], uint32(superblock.FirstMetaBg))
+	creationTime, creationTimeHigh := splitTime(superblock.TimeCreation)
+	le.PutUint32(data[264:], creationTime)
+	for i, block := range superblock.JournalBlocks {
+		le.PutUint32(data[268+i*4:], uint32(block))
+	}
+
+	le.PutUint32(data[336:], uint32(superblock.NumBlocksHigh))
+	le.PutUint32(data[340:], uint32(superblock.NumResBlocksHigh))
+	le.PutUint32(data[344:], uint32(superblock.NumFreeBlocksHigh))
+	le.PutUint16(data[348:], uint16(superblock.MinExtraIsize))
+	le.PutUint16(data[350:], uint16(superblock.WantExtraIsize))
+	le.PutUint32(data[352:], uint32(superblock.Flags))
+	le.PutUint16(data[356:], uint16(superblock.RaidStride))
+	le.PutUint16(data[358:], uint16(superblock.MmpUpdateInterval))
+	le.PutUint64(data[360:], uint64(superblock.MmpBlock))
+	le.PutUint32(data[368:], uint32(superblock.RaidStripeWidth))
+	data[372] = byte(superblock.LogGroupsPerFlex)
+	data[373] = byte(superblock.ChecksumType)
+	data[374] = byte(superblock.EncryptionLevel)
+	le.PutUint64(data[376:], uint64(superblock.KbytesWritten))
+	le.PutUint32(data[384:], uint32(superblock.SnapshotInode))
+	le.PutUint32(data[388:], uint32(superblock.SnapshotId))
+	le.PutUint64(data[392:], uint64(superblock.SnapshotResBlocks))
+	le.PutUint32(data[400:], uint32(superblock.SnapshotList))
+	le.PutUint32(data[404:], uint32(superblock.ErrorCount))
+	firstErrorTimeHigh, firstErrorCode := encodeErrorRecord(data, &superblock.FirstError, 408, 412, 416, 424, 456)
+	lastErrorTimeHigh, lastErrorCode := encodeErrorRecord(data, &superblock.LastError, 460, 464, 472, 480, 468)
+	copy(data[512:576], superblock.MountOptions)
+	le.PutUint32(data[576:], uint32(superblock.UserQuotaInode))
+	le.PutUint32(data[580:], uint32(superblock.GroupQuotaInode))
+	le.PutUint32(data[584:], uint32(superblock.OverheadClusters))
+	le.PutUint32(data[588:], uint32(superblock.BackupBgs[0]))
+	le.PutUint32(data[592:], uint32(superblock.BackupBgs[1]))
+	copy(data[596:600], superblock.EncryptAlgorithms[:])
+	copy(data[600:616], superblock.EncryptPasswordSalt[:])
+	le.PutUint32(data[616:], uint32(superblock.LostFoundInode))
+	le.PutUint32(data[620:], uint32(superblock.ProjectQuotaInode))
+	le.PutUint32(data[624:], uint32(superblock.ChecksumSeed))
+	data[628] = writeTimeHigh
+	data[629] = mountTimeHigh
+	data[630] = creationTimeHigh
+	data[631] = checkTimeHigh
+	data[632] = firstErrorTimeHigh
+	data[633] = lastErrorTimeHigh
+	data[634] = firstErrorCode
+	data[635] = lastErrorCode
+	le.PutUint16(data[636:], uint16(superblock.Encoding))
+	le.PutUint16(data[638:], uint16(superblock.EncodingFlags))
+	le.PutUint32(data[640:], uint32(superblock.OrphanFileInode))
+	le.PutUint32(data[1020:], uint32(superblock.Checksum))
+	return data
+}
+
+// Decode reads every field of an on-disk superblock. The layout values
+// that aren't stored on disk are left for the caller to compute.
+func Decode(data []byte) (*Superblock, error) {
+	if len(data) < Size {
+		return nil, errors.New("superblock is too short")
+	}
+	le := binary.LittleEndian
+	superblock := &Superblock{}
+	superblock.MagicNum = int(le.Uint16(data[56:]))
+	if superblock.MagicNum != 0xEF53 {
+		return nil, errors.New("invalid superblock magic number")
+	}
+
+	superblock.NumInodes = int(le.Uint32(data[0:]))
+	superblock.NumBlocks = int(le.Uint32(data[4:]))
+	superblock.NumResBlocks = int(le.Uint32(data[8:]))
+	superblock.NumFreeBlocks = int(le.Uint32(data[12:]))
+	superblock.NumFreeInodes = int(le.Uint32(data[16:]))
+	superblock.FirstBlockId = int(le.Uint32(data[20:]))
+	superblock.LogBlockSize = int(le.Uint32(data[24:]))
+	superblock.LogFragSize = int(le.Uint32(data[28:]))
+	superblock.NumBlocksPerGroup = int(le.Uint32(data[32:]))
+	superblock.NumFragsPerGroup = int(le.Uint32(data[36:]))
+	superblock.NumInodesPerGroup = int(le.Uint32(data[40:]))
+	superblock.TimeLastMount = joinTime(le.Uint32(data[44:]), data[629])
+	superblock.TimeLastWrite = joinTime(le.Uint32(data[48:]), data[628])
+	superblock.NumMountsSinceCheck = int(le.Uint16(data[52:]))
+	superblock.NumMountsMax = int(int16(le.Uint16(data[54:])))
+	superblock.State = int(le.Uint16(data[58:]))
+	superblock.ErrorAction = int(le.Uint16(data[60:]))
+	superblock.RevMinor = int(le.Uint16(data[62:]))
+	superblock.TimeLastCheck = joinTime(le.Uint32(data[64:]), data[631])
+	superblock.TimeBetweenCheck = int64(le.Uint32(data[68:]))
+	superblock.CreatorOs = int(le.Uint32(data[72:]))
+	superblock.RevLevel = int(le.Uint32(data[76:]))
+	superblock.DefResUid = int(le.Uint16(data[80:]))
+	superblock.DefResGid = int(le.Uint16(data[82:]))
+	superblock.FirstInodeIndex = 11
+	superblock.InodeSize = 128
+	if superblock.RevLevel > 0 {
+		superblock.FirstInodeIndex = int(le.Uint32(data[84:]))
+		superblock.InodeSize = int(le.Uint16(data[88:]))
+	}
+	superblock.BgNum = int(le.Uint16(data[90:]))
+	superblock.FeaturesCompatible = int(le.Uint32(data[92:]))
+	superblock.FeaturesIncompatible = int(le.Uint32(data[96:]))
+	superblock.FeaturesReadOnlyCompatible = int(le.Uint32(data[100:]))
+	copy(superblock.VolumeId[:], data[104:120])
+	superblock.VolumeName = string(data[120:136])
+	superblock.LastMountPath = string(data[136:200])
+	superblock.AlgorithmUsageBitmap = int(le.Uint32(data[200:]))
+
+	superblock.PreallocBlocks = int(data[204])
+	superblock.PreallocDirBlocks = int(data[205])
+	superblock.ReservedGdtBlocks = int(le.Uint16(data[206:]))
+
+	copy(superblock.JournalUuid[:], data[208:224])
+	superblock.JournalInode = int(le.Uint32(data[224:]))
+	superblock.JournalDevice = int(le.Uint32(data[228:]))
+	superblock.LastOrphan = int(le.Uint32(data[232:]))
+	copy(superblock.HashSeed[:], data[236:252])
+	superblock.DefaultHashVersion = int(data[252])
+	superblock.JournalBackupType = int(data[253])
+	superblock.DescSize = int(le.Uint16(data[254:]))
+	superblock.DefaultMountOptions = int(le.Uint32(data[256:]))
+	superblock.FirstMetaBg = int(le.Uint32(data[260:]))
+	superblock.TimeCreation = joinTime(le.Uint32(data[264:]), data[630])
+	for i := range superblock.JournalBlocks {
+		superblock.JournalBlocks[i] = int(le.Uint32(data[268+i*4:]))
+	}
+
+	superblock.NumBlocksHigh = int(le.Uint32(data[336:]))
+	superblock.NumResBlocksHigh = int(le.Uint32(data[340:]))
+	superblock.NumFreeBlocksHigh = int(le.Uint32(data[344:]))
+	superblock.MinExtraIsize = int(le.Uint16(data[348:]))
+	superblock.WantExtraIsize = int(le.Uint16(data[350:]))
+	superblock.Flags = int(le.Uint32(data[352:]))
+	superblock.RaidStride = int(le.Uint16(data[356:]))
+	superblock.MmpUpdateInterval = int(le.Uint16(data[358:]))
+	superblock.MmpBlock = int64(le.Uint64(data[360:]))
+	superblock.RaidStripeWidth = int(le.Uint32(data[368:]))
+	superblock.LogGroupsPerFlex = int(data[372])
+	superblock.ChecksumType = int(data[373])
+	superblock.EncryptionLevel = int(data[374])
+	superblock.KbytesWritten = int64(le.Uint64(data[376:]))
+	superblock.SnapshotInode = int(le.Uint32(data[384:]))
+	superblock.SnapshotId = int(le.Uint32(data[388:]))
+	superblock.SnapshotResBlocks = int64(le.Uint64(data[392:]))
+	superblock.SnapshotList = int(le.Uint32(data[400:]))
+	superblock.ErrorCount = int(le.Uint32(data[404:]))
+	superblock.FirstError = decodeErrorRecord(data, data[632], data[634], 408, 412, 416, 424, 456)
+	superblock.LastError = decodeErrorRecord(data, data[633], data[635], 460, 464, 472, 480, 468)
+	superblock.MountOptions = string(data[512:576])
+	superblock.UserQuotaInode = int(le.Uint32(data[576:]))
+	superblock.GroupQuotaInode = int(le.Uint32(data[580:]))
+	superblock.OverheadClusters = int(le.Uint32(data[584:]))
+	superblock.BackupBgs[0] = int(le.Uint32(data[588:]))
+	superblock.BackupBgs[1] = int(le.Uint32(data[592:]))
+	copy(superblock.EncryptAlgorithms[:], data[596:600])
+	copy(superblock.EncryptPasswordSalt[:], data[600:616])
+	superblock.LostFoundInode = int(le.Uint32(data[616:]))
+	superblock.ProjectQuotaInode = int(le.Uint32(data[620:]))
+	superblock.ChecksumSeed = int(le.Uint32(data[624:]))
+	superblock.Encoding = int(le.Uint16(data[636:]))
+	superblock.EncodingFlags = int(le.Uint16(data[638:]))
+	superblock.OrphanFileInode = int(le.Uint32(data[640:]))
+	superblock.Checksum = int(le.Uint32(data[1020:]))
+	return superblock, nil
+}
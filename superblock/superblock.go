@@ -1,10 +1,8 @@
 package superblock
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"math"
 	"sort"
 
@@ -12,6 +10,65 @@ import (
 	binary_pack "github.com/roman-kachanovsky/go-binary-pack/binary-pack"
 )
 
+const (
+	FeatureCompatHasJournal = 0x0004
+	FeatureCompatExtAttr    = 0x0008
+	FeatureCompatDirIndex   = 0x0020
+
+	FeatureIncompatFiletype = 0x0002
+	FeatureIncompatRecover  = 0x0004
+
+	FeatureReadOnlyCompatSparseSuper = 0x0001
+	FeatureReadOnlyCompatLargeFile   = 0x0002
+	FeatureReadOnlyCompatGdtCsum     = 0x0010
+
+	FlagSignedHash   = 0x0001
+	FlagUnsignedHash = 0x0002
+
+	ErrorsContinue = 1
+	ErrorsReadOnly = 2
+	ErrorsPanic    = 3
+
+	MountOptDebug          = 0x0001
+	MountOptBsdGroups      = 0x0002
+	MountOptUserXattr      = 0x0004
+	MountOptAcl            = 0x0008
+	MountOptUid16          = 0x0010
+	MountOptJournalData    = 0x0020
+	MountOptJournalOrdered = 0x0040
+	MountOptJournalWback   = 0x0060
+	MountOptJournalMask    = 0x0060
+	MountOptNoBarrier      = 0x0100
+	MountOptBlockValidity  = 0x0200
+	MountOptDiscard        = 0x0400
+	MountOptNoDelalloc     = 0x0800
+)
+
+// MountOptionNames are the names tune2fs uses for the default mount
+// options. The journal modes share two bits, so they're values rather
+// than flags.
+var MountOptionNames = map[string]int{
+	"debug":                  MountOptDebug,
+	"bsdgroups":              MountOptBsdGroups,
+	"user_xattr":             MountOptUserXattr,
+	"acl":                    MountOptAcl,
+	"uid16":                  MountOptUid16,
+	"journal_data":           MountOptJournalData,
+	"journal_data_ordered":   MountOptJournalOrdered,
+	"journal_data_writeback": MountOptJournalWback,
+	"nobarrier":              MountOptNoBarrier,
+	"block_validity":         MountOptBlockValidity,
+	"discard":                MountOptDiscard,
+	"nodelalloc":             MountOptNoDelalloc,
+}
+
+// ErrorBehaviorNames are the names of the ErrorAction values.
+var ErrorBehaviorNames = map[string]int{
+	"continue":   ErrorsContinue,
+	"remount-ro": ErrorsReadOnly,
+	"panic":      ErrorsPanic,
+}
+
 type Superblock struct {
 	BgNum                      int
 	NumFreeBlocks              int
@@ -40,19 +97,70 @@ type Superblock struct {
 	RevLevel                   int
 	DefResUid                  int
 	DefResGid                  int
+	DefaultMountOptions        int
 	FeaturesCompatible         int
 	FeaturesIncompatible       int
 	FeaturesReadOnlyCompatible int
 	LogBlockSize               int
 	LogFragSize                int
+	DefaultHashVersion         int
+	JournalInode               int
+	JournalDevice              int
+	JournalBackupType          int
+	MinExtraIsize              int
+	WantExtraIsize             int
+	Flags                      int
+	AlgorithmUsageBitmap       int
+	PreallocBlocks             int
+	PreallocDirBlocks          int
+	ReservedGdtBlocks          int
+	LastOrphan                 int
+	DescSize                   int
+	FirstMetaBg                int
+	NumBlocksHigh              int
+	NumResBlocksHigh           int
+	NumFreeBlocksHigh          int
+	RaidStride                 int
+	MmpUpdateInterval          int
+	RaidStripeWidth            int
+	LogGroupsPerFlex           int
+	ChecksumType               int
+	EncryptionLevel            int
+	SnapshotInode              int
+	SnapshotId                 int
+	SnapshotList               int
+	ErrorCount                 int
+	UserQuotaInode             int
+	GroupQuotaInode            int
+	OverheadClusters           int
+	LostFoundInode             int
+	ProjectQuotaInode          int
+	ChecksumSeed               int
+	Encoding                   int
+	EncodingFlags              int
+	OrphanFileInode            int
+	Checksum                   int
+	MmpBlock                   int64
+	KbytesWritten              int64
+	SnapshotResBlocks          int64
 	TimeLastMount              int64
 	TimeLastWrite              int64
 	TimeLastCheck              int64
 	TimeBetweenCheck           int64
+	TimeCreation               int64
 	SaveCopies                 bool
 	LastMountPath              string
+	MountOptions               string
 	VolumeName                 string
 	VolumeId                   [16]byte
+	HashSeed                   [16]byte
+	JournalUuid                [16]byte
+	JournalBlocks              [17]int
+	BackupBgs                  [2]int
+	EncryptAlgorithms          [4]byte
+	EncryptPasswordSalt        [16]byte
+	FirstError                 ErrorRecord
+	LastError                  ErrorRecord
 	CopyBlockGroupIds          []int
 	Device                     *device.Device
 }
@@ -82,7 +190,7 @@ func (superblock *Superblock) SetNumFreeInodes(numFreeInodes int) error {
 func (superblock *Superblock) SetTimeLastMount(timeLastMount int64) error {
 	superblock.TimeLastMount = timeLastMount
 	bp := new(binary_pack.BinaryPack)
-	bytes, err := bp.Pack([]string{"I"}, []interface{}{timeLastMount})
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{int(timeLastMount)})
 	if err != nil {
 		return err
 	}
@@ -93,7 +201,7 @@ func (superblock *Superblock) SetTimeLastMount(timeLastMount int64) error {
 func (superblock *Superblock) SetTimeLastWrite(timeLastWrite int64) error {
 	superblock.TimeLastWrite = timeLastWrite
 	bp := new(binary_pack.BinaryPack)
-	bytes, err := bp.Pack([]string{"I"}, []interface{}{timeLastWrite})
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{int(timeLastWrite)})
 	if err != nil {
 		return err
 	}
@@ -104,7 +212,7 @@ func (superblock *Superblock) SetTimeLastWrite(timeLastWrite int64) error {
 func (superblock *Superblock) SetNumMountsSinceCheck(numMountsSinceCheck int) error {
 	superblock.NumMountsSinceCheck = numMountsSinceCheck
 	bp := new(binary_pack.BinaryPack)
-	bytes, err := bp.Pack([]string{"I"}, []interface{}{numMountsSinceCheck})
+	bytes, err := bp.Pack([]string{"H"}, []interface{}{numMountsSinceCheck})
 	if err != nil {
 		return err
 	}
@@ -112,22 +220,249 @@ func (superblock *Superblock) SetNumMountsSinceCheck(numMountsSinceCheck int) er
 	return nil
 }
 
+// SetNumMountsMax sets the number of mounts after which the filesystem
+// should be checked, or -1 to never check it because of mounts.
+func (superblock *Superblock) SetNumMountsMax(numMountsMax int) error {
+	superblock.NumMountsMax = numMountsMax
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"h"}, []interface{}{numMountsMax})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(54, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetErrorAction(errorAction int) error {
+	superblock.ErrorAction = errorAction
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"H"}, []interface{}{errorAction})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(60, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetTimeLastCheck(timeLastCheck int64) error {
+	superblock.TimeLastCheck = timeLastCheck
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{int(timeLastCheck)})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(64, bytes)
+	return nil
+}
+
+// SetTimeBetweenCheck sets the maximum number of seconds between checks,
+// or 0 to never check the filesystem because of time.
+func (superblock *Superblock) SetTimeBetweenCheck(timeBetweenCheck int64) error {
+	superblock.TimeBetweenCheck = timeBetweenCheck
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{int(timeBetweenCheck)})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(68, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetNumResBlocks(numResBlocks int) error {
+	superblock.NumResBlocks = numResBlocks
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{numResBlocks})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(8, bytes)
+	return nil
+}
+
+// SetDefRes sets the user and group allowed to use the reserved blocks.
+func (superblock *Superblock) SetDefRes(defResUid, defResGid int) error {
+	superblock.DefResUid = defResUid
+	superblock.DefResGid = defResGid
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"H", "H"}, []interface{}{defResUid, defResGid})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(80, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetVolumeId(volumeId [16]byte) {
+	superblock.VolumeId = volumeId
+	superblock.WriteData(104, volumeId[:])
+}
+
+func (superblock *Superblock) SetDefaultMountOptions(defaultMountOptions int) error {
+	superblock.DefaultMountOptions = defaultMountOptions
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{defaultMountOptions})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(256, bytes)
+	return nil
+}
+
+// SetMountOptions sets the mount options the kernel applies before those
+// given to mount (s_mount_opts).
+func (superblock *Superblock) SetMountOptions(mountOptions string) error {
+	if len(mountOptions) > 63 {
+		return errors.New("mount options are longer than 63 bytes")
+	}
+	superblock.MountOptions = mountOptions
+	bp := new(binary_pack.BinaryPack)
+	mountOptionsBytes, err := bp.Pack([]string{"64s"}, []interface{}{mountOptions})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(512, mountOptionsBytes)
+	return nil
+}
+
 func (superblock *Superblock) SetVolumeName(volumeName string) error {
+	if len(volumeName) > 16 {
+		return errors.New("volume name is longer than 16 bytes")
+	}
 	superblock.VolumeName = volumeName
 	bp := new(binary_pack.BinaryPack)
-	volumeNameBytes, err := bp.Pack([]string{fmt.Sprintf("%vs", len(volumeName))}, []interface{}{volumeName})
+	volumeNameBytes, err := bp.Pack([]string{"16s"}, []interface{}{volumeName})
 	if err != nil {
 		return err
 	}
-	buffer := make([]byte, 1)
-	binary.PutVarint(buffer, 0)
-	superblock.WriteData(120, bytes.Join([][]byte{volumeNameBytes, buffer}, []byte("")))
+	superblock.WriteData(120, volumeNameBytes)
+	return nil
+}
+
+func (superblock *Superblock) SetLastMountPath(lastMountPath string) error {
+	if len(lastMountPath) > 64 {
+		return errors.New("last mounted directory is longer than 64 bytes")
+	}
+	superblock.LastMountPath = lastMountPath
+	bp := new(binary_pack.BinaryPack)
+	lastMountPathBytes, err := bp.Pack([]string{"64s"}, []interface{}{lastMountPath})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(136, lastMountPathBytes)
+	return nil
+}
+
+func (superblock *Superblock) SetFeaturesCompatible(featuresCompatible int) error {
+	superblock.FeaturesCompatible = featuresCompatible
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{featuresCompatible})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(92, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetJournalInode(journalInode int) error {
+	superblock.JournalInode = journalInode
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{journalInode})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(224, bytes)
+	return nil
+}
+
+// SetJournalBlocks stores a backup of the block pointers and size of the
+// journal inode, which e2fsck uses if the inode gets corrupted. An empty
+// array clears the backup.
+func (superblock *Superblock) SetJournalBlocks(journalBlocks [17]int) error {
+	superblock.JournalBackupType = 1
+	if journalBlocks == [17]int{} {
+		superblock.JournalBackupType = 0
+	}
+	superblock.JournalBlocks = journalBlocks
+	format := []string{}
+	values := []interface{}{}
+	for _, block := range journalBlocks {
+		format = append(format, "I")
+		values = append(values, block)
+	}
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack(format, values)
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(253, []byte{uint8(superblock.JournalBackupType)})
+	superblock.WriteData(268, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetFeaturesIncompatible(featuresIncompatible int) error {
+	superblock.FeaturesIncompatible = featuresIncompatible
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{featuresIncompatible})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(96, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetFeaturesReadOnlyCompatible(featuresReadOnlyCompatible int) error {
+	superblock.FeaturesReadOnlyCompatible = featuresReadOnlyCompatible
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{featuresReadOnlyCompatible})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(100, bytes)
+	return nil
+}
+
+func (superblock *Superblock) SetHashSeed(hashSeed [16]byte) {
+	superblock.HashSeed = hashSeed
+	superblock.WriteData(236, hashSeed[:])
+}
+
+func (superblock *Superblock) SetDefaultHashVersion(defaultHashVersion int) {
+	superblock.DefaultHashVersion = defaultHashVersion
+	superblock.WriteData(252, []byte{uint8(defaultHashVersion)})
+}
+
+func (superblock *Superblock) SetFlags(flags int) error {
+	superblock.Flags = flags
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"I"}, []interface{}{flags})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(352, bytes)
+	return nil
+}
+
+// SetExtraIsize sets the size of the large inode fields that every inode
+// has (s_min_extra_isize) and that new inodes should have
+// (s_want_extra_isize).
+func (superblock *Superblock) SetExtraIsize(minExtraIsize, wantExtraIsize int) error {
+	superblock.MinExtraIsize = minExtraIsize
+	superblock.WantExtraIsize = wantExtraIsize
+	bp := new(binary_pack.BinaryPack)
+	bytes, err := bp.Pack([]string{"H", "H"}, []interface{}{minExtraIsize, wantExtraIsize})
+	if err != nil {
+		return err
+	}
+	superblock.WriteData(348, bytes)
 	return nil
 }
 
 func (superblock *Superblock) WriteData(offset int64, data []byte) {
 	for _, groupId := range superblock.CopyBlockGroupIds {
-		sbStart := 1024 + groupId*superblock.NumBlocksPerGroup*superblock.BlockSize
+		sbStart := 1024
+		if groupId > 0 {
+			sbStart = (groupId*superblock.NumBlocksPerGroup + superblock.FirstBlockId) * superblock.BlockSize
+		}
 		superblock.Device.Write(int64(sbStart)+offset, data)
 		if !superblock.SaveCopies {
 			break
@@ -135,26 +470,76 @@ func (superblock *Superblock) WriteData(offset int64, data []byte) {
 	}
 }
 
+// Load reads the primary superblock of an existing filesystem and
+// recomputes the layout values that aren't stored on disk.
+func Load(filesystemDevice *device.Device) (*Superblock, error) {
+	superblock, err := Decode(filesystemDevice.Read(1024, Size))
+	if err != nil {
+		return nil, err
+	}
+	superblock.Device = filesystemDevice
+
+	if superblock.LogBlockSize > 2 || superblock.NumBlocksPerGroup == 0 || superblock.NumInodesPerGroup == 0 {
+		return nil, errors.New("unsupported superblock geometry")
+	}
+	superblock.BlockSize = 1024 << superblock.LogBlockSize
+	superblock.NumBlockGroups = int(math.Ceil(float64(superblock.NumBlocks-superblock.FirstBlockId) / float64(superblock.NumBlocksPerGroup)))
+	superblock.LastBgId = superblock.NumBlockGroups - 1
+	superblock.BgdtBlocks = int(math.Ceil(float64(superblock.NumBlockGroups*32) / float64(superblock.BlockSize)))
+	superblock.InodeTableBlocks = int(math.Ceil(float64(superblock.NumInodesPerGroup*superblock.InodeSize) / float64(superblock.BlockSize)))
+
+	superblock.CopyBlockGroupIds = []int{0}
+	for groupId := 1; groupId < superblock.NumBlockGroups; groupId++ {
+		if superblock.HasSuperblockCopy(groupId) {
+			superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, groupId)
+		}
+	}
+	superblock.SaveCopies = true
+
+	return superblock, nil
+}
+
+// HasSuperblockCopy reports whether the block group holds a copy of the
+// superblock and the block group descriptor table.
+func (superblock *Superblock) HasSuperblockCopy(groupId int) bool {
+	if groupId <= 1 || superblock.FeaturesReadOnlyCompatible&FeatureReadOnlyCompatSparseSuper == 0 {
+		return true
+	}
+	for _, base := range []int{3, 5, 7} {
+		power := base
+		for power < groupId {
+			power *= base
+		}
+		if power == groupId {
+			return true
+		}
+	}
+	return false
+}
+
 func New(
 	byteOffset int64,
 	filesystemDevice *device.Device,
 	bgNum int,
 	blockSize int,
+	inodeSize int,
+	inodesPerGroup int,
 	numBlocks int,
 	currentTime int64,
 	volumeId [16]byte,
+	revision int,
 ) (*Superblock, error) {
 	superblock := &Superblock{
 		BgNum:     bgNum,
 		BlockSize: blockSize,
+		InodeSize: inodeSize,
 		NumBlocks: numBlocks,
 		VolumeId:  volumeId,
 		Device:    filesystemDevice,
 	}
 
 	superblock.FirstInodeIndex = 11
-	superblock.InodeSize = 128
-	superblock.NumInodesPerGroup = superblock.BlockSize * 8
+	superblock.NumInodesPerGroup = inodesPerGroup
 	superblock.NumResBlocks = int(float64(superblock.NumBlocks) * 0.05)
 	superblock.NumBlocksPerGroup = superblock.BlockSize * 8
 	superblock.NumBlockGroups = int(math.Ceil(float64(superblock.NumBlocks) / float64(superblock.NumBlocksPerGroup)))
@@ -165,23 +550,20 @@ func New(
 		superblock.FirstBlockId = 1
 	}
 
+	// Revision 0 filesystems have no features, so every group gets a copy
+	// of the superblock instead of only the sparse_super ones.
+	superblock.RevLevel = revision
+	superblock.FeaturesCompatible = 0
+	superblock.FeaturesIncompatible = 0
+	superblock.FeaturesReadOnlyCompatible = 0
+	if revision > 0 {
+		superblock.FeaturesIncompatible = FeatureIncompatFiletype
+		superblock.FeaturesReadOnlyCompatible = FeatureReadOnlyCompatSparseSuper
+	}
 	superblock.CopyBlockGroupIds = []int{}
-	if superblock.NumBlockGroups > 1 {
-		superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, 1)
-		last3 := 3
-		for last3 < superblock.NumBlockGroups {
-			superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, last3)
-			last3 *= 3
-		}
-		last5 := 5
-		for last5 < superblock.NumBlockGroups {
-			superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, last5)
-			last5 *= 5
-		}
-		last7 := 7
-		for last7 < superblock.NumBlockGroups {
-			superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, last7)
-			last7 *= 7
+	for groupId := 1; groupId < superblock.NumBlockGroups; groupId++ {
+		if superblock.HasSuperblockCopy(groupId) {
+			superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, groupId)
 		}
 	}
 
@@ -236,37 +618,17 @@ func New(
 	superblock.RevMinor = 0
 	superblock.TimeLastCheck = currentTime
 	superblock.TimeBetweenCheck = 15552000
+	superblock.TimeCreation = currentTime
 	superblock.CreatorOs = 0
-	superblock.RevLevel = 1
 	superblock.DefResUid = 0
 	superblock.DefResGid = 0
-	superblock.FeaturesCompatible = 0
-	superblock.FeaturesIncompatible = 2
-	superblock.FeaturesReadOnlyCompatible = 1
 
 	buffer := make([]byte, 1)
 	binary.PutVarint(buffer, 0)
 	superblock.SetVolumeName(string(buffer))
 	superblock.LastMountPath = "/" + string(buffer)
 
-	format := []string{"I", "I", "I", "I", "I", "I", "I", "i", "I", "I", "I", "I", "I", "H", "H", "H", "H", "H", "H", "I", "I", "I", "I", "H", "H", "I", "H", "H", "I", "I", "I", "16s", "16s", "64s"}
-	values := []interface{}{superblock.NumInodes, superblock.NumBlocks, superblock.NumResBlocks, superblock.NumFreeBlocks, superblock.NumFreeInodes,
-		superblock.FirstBlockId, superblock.LogBlockSize, superblock.LogFragSize, superblock.NumBlocksPerGroup, superblock.NumFragsPerGroup,
-		superblock.NumInodesPerGroup, int(superblock.TimeLastMount), int(superblock.TimeLastWrite), superblock.NumMountsSinceCheck, superblock.NumMountsMax,
-		superblock.MagicNum, superblock.State, superblock.ErrorAction, superblock.RevMinor, int(superblock.TimeLastCheck), int(superblock.TimeBetweenCheck), superblock.CreatorOs,
-		superblock.RevLevel, superblock.DefResUid, superblock.DefResGid, superblock.FirstInodeIndex, superblock.InodeSize, superblock.BgNum, superblock.FeaturesCompatible,
-		superblock.FeaturesIncompatible, superblock.FeaturesReadOnlyCompatible, string(superblock.VolumeId[:]), superblock.VolumeName, superblock.LastMountPath}
-	bp := new(binary_pack.BinaryPack)
-	sbBytes, err := bp.Pack(format, values)
-	if err != nil {
-		return superblock, errors.New("unable to pack bytes: " + err.Error())
-	}
-	emptyBytes := []byte("")
-	for i := 0; i < 824; i++ {
-		emptyBytes = binary.AppendVarint(emptyBytes, 0)
-	}
-	newBytes := bytes.Join([][]byte{sbBytes, emptyBytes}, []byte(""))
-	filesystemDevice.Write(byteOffset, newBytes)
+	filesystemDevice.Write(byteOffset, superblock.Encode())
 
 	superblock.CopyBlockGroupIds = append(superblock.CopyBlockGroupIds, 0)
 	sort.Ints(superblock.CopyBlockGroupIds)
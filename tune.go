@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ErrorNoInternet/mkfs.ext2/device"
+	"github.com/ErrorNoInternet/mkfs.ext2/filesystem"
+	"github.com/ErrorNoInternet/mkfs.ext2/htree"
+	"github.com/ErrorNoInternet/mkfs.ext2/superblock"
+	"github.com/google/uuid"
+)
+
+// featureNames are the mke2fs names of the compatible, incompatible and
+// read-only compatible features.
+var featureNames = [3]map[int]string{
+	{
+		superblock.FeatureCompatHasJournal: "has_journal",
+		superblock.FeatureCompatExtAttr:    "ext_attr",
+		superblock.FeatureCompatDirIndex:   "dir_index",
+	},
+	{
+		superblock.FeatureIncompatFiletype: "filetype",
+		superblock.FeatureIncompatRecover:  "needs_recovery",
+	},
+	{
+		superblock.FeatureReadOnlyCompatSparseSuper: "sparse_super",
+		superblock.FeatureReadOnlyCompatLargeFile:   "large_file",
+		superblock.FeatureReadOnlyCompatGdtCsum:     "uninit_bg",
+	},
+}
+
+func tuneCommand(arguments []string) {
+	flags := flag.NewFlagSet("tune", flag.ExitOnError)
+	var devicePath string
+	var list bool
+	values := tuneValues{}
+	flags.StringVar(&devicePath, "device", "", "The device whose filesystem you want to change")
+	flags.StringVar(&values.label, "L", "", "The volume label (at most 16 bytes)")
+	flags.StringVar(&values.volumeId, "U", "", "The volume UUID: a UUID, random, time or clear")
+	flags.StringVar(&values.lastMountPath, "M", "", "The directory the filesystem was last mounted on")
+	flags.IntVar(&values.maxMountCount, "c", 0, "The number of mounts after which the filesystem is checked (-1 to disable)")
+	flags.IntVar(&values.mountCount, "C", 0, "The number of mounts since the last check")
+	flags.StringVar(&values.interval, "i", "", "The maximum time between checks in days, or with a d, w or m suffix (0 to disable)")
+	flags.StringVar(&values.errorBehavior, "e", "", "The behavior on errors: continue, remount-ro or panic")
+	flags.StringVar(&values.reservedPercent, "m", "", "The percentage of blocks reserved for the reserved user and group")
+	flags.IntVar(&values.reservedBlocks, "r", 0, "The number of blocks reserved for the reserved user and group")
+	flags.IntVar(&values.reservedUid, "u", 0, "The user that may use the reserved blocks")
+	flags.IntVar(&values.reservedGid, "g", 0, "The group that may use the reserved blocks")
+	flags.StringVar(&values.mountOptions, "o", "", "Comma separated default mount options to set (or clear with a ^ prefix)")
+	flags.StringVar(&values.extendedOptions, "E", "", "Comma separated extended options: mount_opts=options")
+	flags.StringVar(&values.features, "O", "", "Comma separated features to enable (or disable with a ^ prefix): dir_index, ext_attr, has_journal, large_file")
+	flags.BoolVar(&list, "l", false, "Print the superblock")
+	flags.Parse(arguments)
+
+	if devicePath == "" {
+		flags.Usage()
+		return
+	}
+	changed := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "device" && f.Name != "l" {
+			changed[f.Name] = true
+		}
+	})
+
+	if len(changed) > 0 {
+		file, err := os.OpenFile(devicePath, os.O_RDWR, 0)
+		if err != nil {
+			fmt.Printf("unable to open file: %v\n", err)
+			return
+		}
+		fs, err := filesystem.Open(file)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		err = tune(fs, changed, &values)
+		fs.Close()
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+	}
+
+	if list {
+		file, err := os.Open(devicePath)
+		if err != nil {
+			fmt.Printf("unable to open file: %v\n", err)
+			return
+		}
+		dev, err := device.Open(file)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		defer dev.Unmount()
+		sb, err := superblock.Load(dev)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		printSuperblock(sb)
+	}
+}
+
+// tuneValues are the values of the tune flags. Only those of flags that
+// were given are applied.
+type tuneValues struct {
+	label, volumeId, lastMountPath, features, mountOptions, extendedOptions, interval, errorBehavior, reservedPercent string
+	maxMountCount, mountCount, reservedBlocks, reservedUid, reservedGid                                               int
+}
+
+// tune applies the changed values to the superblock, which writes them to
+// every copy.
+func tune(fs *filesystem.Filesystem, changed map[string]bool, values *tuneValues) error {
+	sb := fs.Superblock
+	if changed["L"] {
+		if err := sb.SetVolumeName(values.label); err != nil {
+			return err
+		}
+	}
+	if changed["U"] {
+		volumeId, err := parseUuid(values.volumeId)
+		if err != nil {
+			return err
+		}
+		fs.SetVolumeId(*volumeId)
+	}
+	if changed["M"] {
+		if err := sb.SetLastMountPath(values.lastMountPath); err != nil {
+			return err
+		}
+	}
+	if changed["c"] {
+		if values.maxMountCount < -1 || values.maxMountCount > 0x7FFF {
+			return errors.New("invalid maximum mount count: " + strconv.Itoa(values.maxMountCount))
+		}
+		if err := sb.SetNumMountsMax(values.maxMountCount); err != nil {
+			return err
+		}
+	}
+	if changed["C"] {
+		if values.mountCount < 0 || values.mountCount > 0xFFFF {
+			return errors.New("invalid mount count: " + strconv.Itoa(values.mountCount))
+		}
+		if err := sb.SetNumMountsSinceCheck(values.mountCount); err != nil {
+			return err
+		}
+	}
+	if changed["i"] {
+		timeBetweenCheck, err := parseInterval(values.interval)
+		if err != nil {
+			return err
+		}
+		if err = sb.SetTimeBetweenCheck(timeBetweenCheck); err != nil {
+			return err
+		}
+	}
+	if changed["e"] {
+		errorAction, err := parseErrorBehavior(values.errorBehavior)
+		if err != nil {
+			return err
+		}
+		if err = sb.SetErrorAction(errorAction); err != nil {
+			return err
+		}
+	}
+	if changed["m"] {
+		percent, err := parseReservedPercent(values.reservedPercent)
+		if err != nil {
+			return err
+		}
+		if err = sb.SetNumResBlocks(int(float64(sb.NumBlocks) * percent / 100)); err != nil {
+			return err
+		}
+	}
+	if changed["r"] {
+		if values.reservedBlocks < 0 || values.reservedBlocks > sb.NumBlocks/2 {
+			return errors.New("invalid reserved blocks count: " + strconv.Itoa(values.reservedBlocks))
+		}
+		if err := sb.SetNumResBlocks(values.reservedBlocks); err != nil {
+			return err
+		}
+	}
+	if changed["u"] || changed["g"] {
+		reservedUid, reservedGid := sb.DefResUid, sb.DefResGid
+		if changed["u"] {
+			reservedUid = values.reservedUid
+		}
+		if changed["g"] {
+			reservedGid = values.reservedGid
+		}
+		if reservedUid < 0 || reservedUid > 0xFFFF || reservedGid < 0 || reservedGid > 0xFFFF {
+			return errors.New("invalid reserved user or group: " + strconv.Itoa(reservedUid) + ":" + strconv.Itoa(reservedGid))
+		}
+		if err := sb.SetDefRes(reservedUid, reservedGid); err != nil {
+			return err
+		}
+	}
+	if changed["o"] {
+		defaultMountOptions, err := parseMountOptions(values.mountOptions, sb.DefaultMountOptions)
+		if err != nil {
+			return err
+		}
+		if err = sb.SetDefaultMountOptions(defaultMountOptions); err != nil {
+			return err
+		}
+	}
+	if changed["E"] {
+		for _, option := range strings.Split(values.extendedOptions, ",") {
+			if option == "" {
+				continue
+			}
+			key, value, _ := strings.Cut(option, "=")
+			if key != "mount_opts" {
+				return errors.New("unknown extended option: " + option)
+			}
+			if err := sb.SetMountOptions(value); err != nil {
+				return err
+			}
+		}
+	}
+	if changed["O"] {
+		for _, feature := range strings.Split(values.features, ",") {
+			if feature == "" {
+				continue
+			}
+			enable := !strings.HasPrefix(feature, "^")
+			if err := fs.SetFeature(strings.TrimPrefix(feature, "^"), enable); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatTime(seconds int64) string {
+	if seconds == 0 {
+		return "n/a"
+	}
+	return time.Unix(seconds, 0).Format(time.ANSIC)
+}
+
+func cString(value string) string {
+	if index := strings.IndexByte(value, 0); index != -1 {
+		return value[:index]
+	}
+	return value
+}
+
+type superblockField struct {
+	name  string
+	value interface{}
+}
+
+// printSuperblock prints the superblock the way dumpe2fs -h does.
+func printSuperblock(sb *superblock.Superblock) {
+	features := []string{}
+	for index, flags := range [3]int{sb.FeaturesCompatible, sb.FeaturesIncompatible, sb.FeaturesReadOnlyCompatible} {
+		for bit := 1; bit != 0 && bit <= flags; bit <<= 1 {
+			if flags&bit == 0 {
+				continue
+			}
+			name, ok := featureNames[index][bit]
+			if !ok {
+				name = fmt.Sprintf("FEATURE_%v%v", "CIR"[index:index+1], bits.TrailingZeros(uint(bit)))
+			}
+			features = append(features, name)
+		}
+	}
+	mountOptions := []string{}
+	for name, value := range superblock.MountOptionNames {
+		mask := value
+		if value&superblock.MountOptJournalMask != 0 {
+			mask = superblock.MountOptJournalMask
+		}
+		if sb.DefaultMountOptions&mask == value {
+			mountOptions = append(mountOptions, name)
+		}
+	}
+	sort.Strings(mountOptions)
+	if len(mountOptions) == 0 {
+		mountOptions = append(mountOptions, "(none)")
+	}
+	errorBehavior := "unknown"
+	for name, value := range superblock.ErrorBehaviorNames {
+		if sb.ErrorAction == value {
+			errorBehavior = name
+		}
+	}
+	state := "not clean"
+	if sb.State&1 != 0 {
+		state = "clean"
+	}
+	volumeName := cString(sb.VolumeName)
+	if volumeName == "" {
+		volumeName = "<none>"
+	}
+	mountOptionsString := cString(sb.MountOptions)
+	if mountOptionsString == "" {
+		mountOptionsString = "(none)"
+	}
+	lastMountPath := cString(sb.LastMountPath)
+	if lastMountPath == "" {
+		lastMountPath = "<not available>"
+	}
+	volumeId := uuid.UUID(sb.VolumeId).String()
+	if sb.VolumeId == [16]byte{} {
+		volumeId = "<none>"
+	}
+	hashName := "unknown"
+	for _, name := range []string{"legacy", "half_md4", "tea"} {
+		if version, _ := htree.HashVersionByName(name); version == sb.DefaultHashVersion {
+			hashName = name
+		}
+	}
+
+	fields := []superblockField{
+		{"Filesystem volume name", volumeName},
+		{"Last mounted on", lastMountPath},
+		{"Filesystem UUID", volumeId},
+		{"Filesystem magic number", fmt.Sprintf("0x%04X", sb.MagicNum)},
+		{"Filesystem revision #", sb.RevLevel},
+		{"Filesystem features", strings.Join(features, " ")},
+		{"Default mount options", strings.Join(mountOptions, " ")},
+		{"Mount options", mountOptionsString},
+		{"Filesystem state", state},
+		{"Errors behavior", errorBehavior},
+		{"Inode count", sb.NumInodes},
+		{"Block count", sb.NumBlocks},
+		{"Reserved block count", sb.NumResBlocks},
+		{"Free blocks", sb.NumFreeBlocks},
+		{"Free inodes", sb.NumFreeInodes},
+		{"First block", sb.FirstBlockId},
+		{"Block size", sb.BlockSize},
+		{"Blocks per group", sb.NumBlocksPerGroup},
+		{"Inodes per group", sb.NumInodesPerGroup},
+		{"Filesystem created", formatTime(sb.TimeCreation)},
+		{"Last mount time", formatTime(sb.TimeLastMount)},
+		{"Last write time", formatTime(sb.TimeLastWrite)},
+		{"Mount count", sb.NumMountsSinceCheck},
+		{"Maximum mount count", sb.NumMountsMax},
+		{"Last checked", formatTime(sb.TimeLastCheck)},
+		{"Check interval", fmt.Sprintf("%v (%v)", sb.TimeBetweenCheck, time.Duration(sb.TimeBetweenCheck)*time.Second)},
+		{"Reserved blocks uid", sb.DefResUid},
+		{"Reserved blocks gid", sb.DefResGid},
+		{"First inode", sb.FirstInodeIndex},
+		{"Inode size", sb.InodeSize},
+		{"Journal inode", sb.JournalInode},
+		{"Default directory hash", hashName},
+		{"Directory Hash Seed", uuid.UUID(sb.HashSeed).String()},
+	}
+	if sb.ErrorCount > 0 {
+		fields = append(fields, []superblockField{
+			{"FS Error count", sb.ErrorCount},
+			{"First error time", formatTime(sb.FirstError.Time)},
+			{"First error function", sb.FirstError.Function},
+			{"First error line #", sb.FirstError.Line},
+			{"Last error time", formatTime(sb.LastError.Time)},
+			{"Last error function", sb.LastError.Function},
+			{"Last error line #", sb.LastError.Line},
+		}...)
+	}
+	for _, field := range fields {
+		fmt.Printf("%-26v%v\n", field.name+":", field.value)
+	}
+}
@@ -0,0 +1,270 @@
+package xattr
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	Magic = 0xEA020000
+
+	IndexUser            = 1
+	IndexPosixAclAccess  = 2
+	IndexPosixAclDefault = 3
+	IndexTrusted         = 4
+	IndexSecurity        = 6
+
+	// MaxRefcount is the number of inodes that can share an attribute
+	// block before another copy is made.
+	MaxRefcount = 1024
+
+	MaxNameLength = 255
+
+	headerSize = 32
+	entrySize  = 16
+)
+
+var prefixes = []struct {
+	index  int
+	prefix string
+}{
+	{IndexUser, "user."},
+	{IndexTrusted, "trusted."},
+	{IndexSecurity, "security."},
+}
+
+// unnamed are the attributes whose namespace holds a single attribute with
+// an empty name.
+var unnamed = []struct {
+	index int
+	name  string
+}{
+	{IndexPosixAclAccess, "system.posix_acl_access"},
+	{IndexPosixAclDefault, "system.posix_acl_default"},
+}
+
+// Attribute is a single extended attribute. Name doesn't include the
+// namespace prefix, which is stored as Index instead.
+type Attribute struct {
+	Index int
+	Name  string
+	Value []byte
+}
+
+// Parse splits a full attribute name such as "user.comment" into its
+// namespace and name.
+func Parse(fullName string, value []byte) (*Attribute, error) {
+	for _, namespace := range unnamed {
+		if fullName == namespace.name {
+			return &Attribute{Index: namespace.index, Value: value}, nil
+		}
+	}
+	for _, namespace := range prefixes {
+		if strings.HasPrefix(fullName, namespace.prefix) {
+			name := strings.TrimPrefix(fullName, namespace.prefix)
+			if len(name) == 0 || len(name) > MaxNameLength {
+				return nil, errors.New("invalid attribute name")
+			}
+			return &Attribute{Index: namespace.index, Name: name, Value: value}, nil
+		}
+	}
+	return nil, errors.New("unsupported attribute namespace")
+}
+
+func (attribute *Attribute) FullName() string {
+	for _, namespace := range unnamed {
+		if namespace.index == attribute.Index {
+			return namespace.name
+		}
+	}
+	for _, namespace := range prefixes {
+		if namespace.index == attribute.Index {
+			return namespace.prefix + attribute.Name
+		}
+	}
+	return attribute.Name
+}
+
+func (attribute *Attribute) entryLength() int {
+	return (entrySize + len(attribute.Name) + 3) &^ 3
+}
+
+func (attribute *Attribute) valueLength() int {
+	return (len(attribute.Value) + 3) &^ 3
+}
+
+// Hash returns the entry hash of the attribute, which covers its name and
+// its value.
+func (attribute *Attribute) Hash() uint32 {
+	var hash uint32
+	for _, c := range []byte(attribute.Name) {
+		hash = hash<<5 ^ hash>>27 ^ uint32(int8(c))
+	}
+	value := make([]byte, attribute.valueLength())
+	copy(value, attribute.Value)
+	for i := 0; i < len(value); i += 4 {
+		hash = hash<<16 ^ hash>>16 ^ binary.LittleEndian.Uint32(value[i:])
+	}
+	return hash
+}
+
+// Sort puts attributes in the order ext2 keeps them in attribute blocks:
+// by namespace, then name length, then name.
+func Sort(attributes []*Attribute) {
+	sort.SliceStable(attributes, func(i, j int) bool {
+		a, b := attributes[i], attributes[j]
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Find returns the position of the attribute with the given namespace and
+// name, or -1.
+func Find(attributes []*Attribute, index int, name string) int {
+	for i, attribute := range attributes {
+		if attribute.Index == index && attribute.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// encodeEntries lays out attributes in data, with the entries starting at
+// entriesStart and the values packed against the end. Value offsets are
+// relative to the start of data.
+func encodeEntries(data []byte, entriesStart int, attributes []*Attribute) error {
+	le := binary.LittleEndian
+	offset := entriesStart
+	valueEnd := len(data)
+	for _, attribute := range attributes {
+		valueOffset := 0
+		if len(attribute.Value) > 0 {
+			valueOffset = valueEnd - attribute.valueLength()
+		}
+		if offset+attribute.entryLength()+4 > valueEnd-attribute.valueLength() {
+			return errors.New("no space for extended attribute")
+		}
+		data[offset] = uint8(len(attribute.Name))
+		data[offset+1] = uint8(attribute.Index)
+		le.PutUint16(data[offset+2:], uint16(valueOffset))
+		le.PutUint32(data[offset+4:], 0)
+		le.PutUint32(data[offset+8:], uint32(len(attribute.Value)))
+		le.PutUint32(data[offset+12:], attribute.Hash())
+		copy(data[offset+entrySize:], attribute.Name)
+		copy(data[valueOffset:], attribute.Value)
+		offset += attribute.entryLength()
+		valueEnd -= attribute.valueLength()
+	}
+	return nil
+}
+
+func decodeEntries(data []byte, entriesStart int) ([]*Attribute, error) {
+	le := binary.LittleEndian
+	attributes := []*Attribute{}
+	offset := entriesStart
+	for {
+		if offset+4 > len(data) {
+			return nil, errors.New("invalid extended attribute entry")
+		}
+		if le.Uint32(data[offset:]) == 0 {
+			return attributes, nil
+		}
+		nameLength := int(data[offset])
+		if offset+entrySize+nameLength > len(data) || le.Uint32(data[offset+4:]) != 0 {
+			return nil, errors.New("invalid extended attribute entry")
+		}
+		valueOffset := int(le.Uint16(data[offset+2:]))
+		valueSize := int(le.Uint32(data[offset+8:]))
+		if valueOffset+valueSize > len(data) {
+			return nil, errors.New("invalid extended attribute value")
+		}
+		attribute := &Attribute{
+			Index: int(data[offset+1]),
+			Name:  string(data[offset+entrySize : offset+entrySize+nameLength]),
+			Value: append([]byte{}, data[valueOffset:valueOffset+valueSize]...),
+		}
+		attributes = append(attributes, attribute)
+		offset += attribute.entryLength()
+	}
+}
+
+// EncodeInode returns the attribute area stored after the extra fields of
+// a large inode, which is size bytes long.
+func EncodeInode(attributes []*Attribute, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if len(attributes) == 0 {
+		return data, nil
+	}
+	if size < 8 {
+		return nil, errors.New("no space for extended attribute")
+	}
+	binary.LittleEndian.PutUint32(data, Magic)
+	if err := encodeEntries(data[4:], 0, attributes); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func DecodeInode(data []byte) ([]*Attribute, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != Magic {
+		return []*Attribute{}, nil
+	}
+	return decodeEntries(data[4:], 0)
+}
+
+// Block is an attribute block referenced by the file ACL field of one or
+// more inodes.
+type Block struct {
+	Refcount   int
+	Attributes []*Attribute
+}
+
+// Hash returns the block hash, which combines the hashes of all of its
+// entries.
+func (block *Block) Hash() uint32 {
+	var hash uint32
+	for _, attribute := range block.Attributes {
+		entryHash := attribute.Hash()
+		if entryHash == 0 {
+			return 0
+		}
+		hash = hash<<16 ^ hash>>16 ^ entryHash
+	}
+	return hash
+}
+
+func (block *Block) Encode(blockSize int) ([]byte, error) {
+	le := binary.LittleEndian
+	Sort(block.Attributes)
+	data := make([]byte, blockSize)
+	le.PutUint32(data[0:], Magic)
+	le.PutUint32(data[4:], uint32(block.Refcount))
+	le.PutUint32(data[8:], 1)
+	le.PutUint32(data[12:], block.Hash())
+	if err := encodeEntries(data, headerSize, block.Attributes); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func DecodeBlock(data []byte) (*Block, error) {
+	le := binary.LittleEndian
+	if len(data) < headerSize || le.Uint32(data[0:]) != Magic || le.Uint32(data[8:]) != 1 {
+		return nil, errors.New("invalid extended attribute block")
+	}
+	attributes, err := decodeEntries(data, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{
+		Refcount:   int(le.Uint32(data[4:])),
+		Attributes: attributes,
+	}, nil
+}